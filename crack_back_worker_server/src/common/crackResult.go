@@ -0,0 +1,146 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// 裂缝识别结果
+// 模型程序在标准输出的最后一个非空行打印一个JSON对象(model_version, detections, frames)，worker解析校验后落库
+
+type CrackResult struct {
+	TaskType 					string 				`bson:"task_type" json:"task_type"`					// 任务类型(image, video)
+	UserId 						int64 				`bson:"user_id" json:"user_id"`						// 发布该任务的用户id
+	TaskName 					string				`bson:"task_name" json:"task_name"`         		// 任务名称
+	TaskId 						int64 				`bson:"task_id" json:"task_id"`						// 任务id
+
+	ModelVersion 				string 				`bson:"model_version" json:"model_version"`			// 模型版本
+	Detections 					[]*CrackDetection 	`bson:"detections" json:"detections"`				// 图片中识别到的裂缝
+	Frames 						[]*CrackFrame 		`bson:"frames" json:"frames"`						// 视频中每一帧识别到的裂缝
+
+	CrackCount 					int 				`bson:"crack_count" json:"crack_count"`				// 裂缝总数
+	MaxLength 					float64 			`bson:"max_length" json:"max_length"`				// 最长裂缝的长度估计
+	MaxWidth 					float64 			`bson:"max_width" json:"max_width"`					// 最宽裂缝的宽度估计
+	CreateTime 					int64 				`bson:"create_time" json:"create_time"`				// 结果产生时间
+}
+
+// 一条裂缝
+type CrackDetection struct {
+	BBox 						[]float64 			`bson:"bbox" json:"bbox"`							// 外接矩形[x, y, w, h]
+	Polygon 					[][]float64 		`bson:"polygon" json:"polygon"`						// 轮廓多边形[[x, y], ...]
+	Confidence 					float64 			`bson:"confidence" json:"confidence"`				// 置信度[0, 1]
+	Length 						float64 			`bson:"length" json:"length"`						// 长度估计
+	Width 						float64 			`bson:"width" json:"width"`							// 宽度估计
+}
+
+// 视频中的一帧
+type CrackFrame struct {
+	FrameIndex 					int64 				`bson:"frame_index" json:"frame_index"`				// 帧序号
+	Timestamp 					float64 			`bson:"timestamp" json:"timestamp"`					// 该帧在视频中的时间(s)
+	Detections 					[]*CrackDetection 	`bson:"detections" json:"detections"`				// 该帧识别到的裂缝
+}
+
+// 从模型程序的标准输出中解析识别结果
+func ParseCrackResult(output []byte) (result *CrackResult, err error) {
+	var (
+		lines 				[]string
+		line 				string
+		i 					int
+	)
+	lines = strings.Split(string(output), "\n")
+	for i = len(lines) - 1; i >= 0; i-- {
+		if line = strings.TrimSpace(lines[i]); line != "" {
+			break
+		}
+	}
+	if line == "" {
+		return nil, ERROR_RESULT_INVALID
+	}
+
+	result = &CrackResult{}
+	if err = json.Unmarshal([]byte(line), result); err != nil {
+		return nil, ERROR_RESULT_INVALID
+	}
+	if err = result.Validate(); err != nil {
+		return nil, err
+	}
+	result.summarize()
+	return result, nil
+}
+
+// 校验识别结果
+func (This *CrackResult) Validate() error {
+	var (
+		detection 			*CrackDetection
+		frame 				*CrackFrame
+	)
+	if This.ModelVersion == "" {
+		return ERROR_RESULT_INVALID
+	}
+	for _, detection = range This.Detections {
+		if !detection.valid() {
+			return ERROR_RESULT_INVALID
+		}
+	}
+	for _, frame = range This.Frames {
+		if frame == nil || frame.FrameIndex < 0 || frame.Timestamp < 0 {
+			return ERROR_RESULT_INVALID
+		}
+		for _, detection = range frame.Detections {
+			if !detection.valid() {
+				return ERROR_RESULT_INVALID
+			}
+		}
+	}
+	return nil
+}
+
+func (This *CrackDetection) valid() bool {
+	var (
+		point 				[]float64
+	)
+	if This == nil || This.Confidence < 0 || This.Confidence > 1 || This.Length < 0 || This.Width < 0 {
+		return false
+	}
+	// 外接矩形和轮廓至少有一个
+	if len(This.BBox) == 0 && len(This.Polygon) == 0 {
+		return false
+	}
+	if len(This.BBox) != 0 && (len(This.BBox) != 4 || This.BBox[2] < 0 || This.BBox[3] < 0) {
+		return false
+	}
+	if len(This.Polygon) != 0 && len(This.Polygon) < 3 {
+		return false
+	}
+	for _, point = range This.Polygon {
+		if len(point) != 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// 统计裂缝总数和最大长度、宽度
+func (This *CrackResult) summarize() {
+	var (
+		detection 			*CrackDetection
+		frame 				*CrackFrame
+		detections 			[]*CrackDetection
+	)
+	detections = append(detections, This.Detections...)
+	for _, frame = range This.Frames {
+		detections = append(detections, frame.Detections...)
+	}
+
+	This.CrackCount = len(detections)
+	This.MaxLength = 0
+	This.MaxWidth = 0
+	for _, detection = range detections {
+		if detection.Length > This.MaxLength {
+			This.MaxLength = detection.Length
+		}
+		if detection.Width > This.MaxWidth {
+			This.MaxWidth = detection.Width
+		}
+	}
+}
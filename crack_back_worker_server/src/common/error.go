@@ -8,9 +8,23 @@ var (
 	ERROR_SCHEDULEPLAN							error = errors.New("错误地删除调度计划，调度计划表中已经不包含该任务")
 	ERROR_KILLTASK								error = errors.New("错误地强杀任务，该任务未正在执行")
 	ERROR_TIMEOUT								error = errors.New("该任务由于执行超时被杀死")
+	ERROR_KILLED								error = errors.New("该任务被强制杀死")
+	ERROR_RESULT_INVALID						error = errors.New("模型程序输出的识别结果不合法")
+	ERROR_TASK_EXPIRED							error = errors.New("该任务在最晚开始时间之前没有开始执行，已过期")
+	ERROR_RUNNER_NOT_FOUND						error = errors.New("该worker没有配置此任务类型的模型程序")
+	ERROR_TASK_OOM								error = errors.New("模型程序的内存超出限额，被OOM终止")
+	ERROR_TASK_PIDS_LIMIT						error = errors.New("模型程序的进程数达到限额")
+	ERROR_SANDBOX_USER							error = errors.New("沙箱的SandboxUid和SandboxGid必须是非0的非特权用户")
 
 	ERROR_LOCK_REQUIRED  						error = errors.New("该锁已经被占用,加锁失败")
+	ERROR_LOCK_LOST  							error = errors.New("任务锁的租约已失效,放弃本次执行")
 	ERROR_TXN_COMMIT  							error = errors.New("提交事务失败")
 
+	ERROR_STATE_TRANSITION						error = errors.New("非法的任务状态转换")
+	ERROR_STATE_CONFLICT						error = errors.New("任务状态被并发修改,更新失败")
+
 	ERROR_IP_NOT_FOUND							error = errors.New("未找到一个非环回地址的IP地址")
+
+	ERROR_BLOB_BACKEND							error = errors.New("不支持的文件存储后端")
+	ERROR_BLOB_REF								error = errors.New("非法的文件引用")
 )
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// 标签和标签选择器：逗号分隔的key=value，value可以用|列出多个取值(如model=v1|v2)
+// worker在配置中声明标签，任务携带标签选择器，选择器的每个key在worker的标签中都有相同的取值时该worker才能执行该任务
+
+var (
+	ERROR_LABELS			error = errors.New("标签格式不合法(key=value,key=value)")
+)
+
+// 由worker根据配置的模型程序自动生成的标签
+const LabelTaskType = "task_type"
+
+// 解析标签
+func ParseLabels(s string) (labels map[string]string, err error) {
+	var (
+		pair 				string
+		key 				string
+		value 				string
+		index 				int
+	)
+	labels = make(map[string]string)
+	for _, pair = range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		if index = strings.Index(pair, "="); index <= 0 || index == len(pair) - 1 {
+			return nil, ERROR_LABELS
+		}
+		key, value = strings.TrimSpace(pair[:index]), strings.TrimSpace(pair[index+1:])
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// 任务的标签选择器是否匹配worker的标签
+func MatchSelector(selector map[string]string, labels map[string]string) bool {
+	var (
+		key 				string
+		value 				string
+		label 				string
+		ok 					bool
+	)
+	for key, value = range selector {
+		if label, ok = labels[key]; !ok || !matchLabelValue(value, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// 选择器的取值和标签的取值有一个相同即可
+func matchLabelValue(want string, have string) bool {
+	var (
+		w 					string
+		h 					string
+	)
+	for _, w = range strings.Split(want, "|") {
+		for _, h = range strings.Split(have, "|") {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	var (
+		labels 				map[string]string
+		s 					string
+		err 				error
+	)
+	if labels, err = ParseLabels(" gpu = a100 , model=v1|v2,,"); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["gpu"] != "a100" || labels["model"] != "v1|v2" {
+		t.Errorf("labels = %v", labels)
+	}
+	if labels, err = ParseLabels(""); err != nil || len(labels) != 0 {
+		t.Errorf("ParseLabels(\"\") = %v, %v", labels, err)
+	}
+	for _, s = range []string{"gpu", "=a100", "gpu="} {
+		if _, err = ParseLabels(s); err != ERROR_LABELS {
+			t.Errorf("ParseLabels(%q) err = %v, want ERROR_LABELS", s, err)
+		}
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	var (
+		labels 				map[string]string
+		cases 				[]struct {
+			selector 			map[string]string
+			want 				bool
+		}
+		index 				int
+		got 				bool
+	)
+	labels = map[string]string{LabelTaskType: "image|video", "gpu": "a100"}
+	cases = []struct {
+		selector 			map[string]string
+		want 				bool
+	}{
+		{nil, true},
+		{map[string]string{"gpu": "a100"}, true},
+		{map[string]string{"gpu": "v100"}, false},
+		{map[string]string{"gpu": "v100|a100"}, true},
+		{map[string]string{LabelTaskType: "video"}, true},
+		{map[string]string{LabelTaskType: "audio"}, false},
+		// worker没有该标签
+		{map[string]string{"zone": "bj"}, false},
+		{map[string]string{"gpu": "a100", "zone": "bj"}, false},
+	}
+	for index = range cases {
+		if got = MatchSelector(cases[index].selector, labels); got != cases[index].want {
+			t.Errorf("MatchSelector(%v) = %v, want %v", cases[index].selector, got, cases[index].want)
+		}
+	}
+}
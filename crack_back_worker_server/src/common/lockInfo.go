@@ -0,0 +1,8 @@
+package common
+
+// 任务锁的value：持有该锁的worker和抢占时间，运维可以直接查看每个任务被哪个worker占用
+type LockInfo struct {
+	Worker 					string 		`json:"worker"`				// 持有该锁的worker(IP)
+	ClaimTime 				int64 		`json:"claim_time"`			// 抢占时间(ms)
+	LeaseId 				int64 		`json:"lease_id"`			// 锁绑定的租约ID(会话模式下同一worker的所有锁相同)
+}
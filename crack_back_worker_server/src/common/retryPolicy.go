@@ -0,0 +1,13 @@
+package common
+
+// 任务失败的错误类别，任务类型的RetryOn中列出的类别失败后会重试
+const (
+	ErrorClassExit 				= "exit"			// 模型程序启动失败或非0退出(崩溃)
+	ErrorClassTimeout 			= "timeout"			// 执行超时
+	ErrorClassResult 			= "result"			// 识别结果不合法
+	ErrorClassInput 			= "input"			// 取待识别文件失败
+	ErrorClassOutput 			= "output"			// 保存输出文件失败
+	ErrorClassExpired 			= "expired"			// 过了最晚开始时间还没有开始执行(不会重试)
+	ErrorClassOOM 				= "oom"				// 内存超出限额被OOM终止
+	ErrorClassPids 				= "pids"			// 进程数达到限额
+)
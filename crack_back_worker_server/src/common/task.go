@@ -7,4 +7,41 @@ type Task struct {
 	TaskId 					int64 		`json:"task_id"`			// 任务id
 
 	TaskTimeOut 			int 		`json:"task_time_out"`		// 任务超时时间
+	InputRef 				string 		`json:"input_ref"`			// 待识别文件在存储器中的引用
+	InputRefs 				[]string 	`json:"input_refs"`			// 流水线步骤的上游输出文件的引用
+	OutputRefs 				[]string 	`json:"output_refs"`		// 执行成功后产生的输出文件的引用(由执行器填写)
+	PipelineId 				int64 		`json:"pipeline_id"`		// 所属流水线的id(不属于流水线时为0)
+	JobId 					int64 		`json:"job_id"`				// 所属批量任务的id(不属于批量任务时为0)
+	Priority 				int 		`json:"priority"`			// 优先级，越大越先执行
+	Selector 				map[string]string `json:"selector"`		// 标签选择器，只有标签匹配的worker才执行该任务
+
+	// 失败重试
+	MaxRetries 				int 		`json:"max_retries"`		// 最大重试次数，0表示使用任务类型的默认值
+	RetryBackoff 			int 		`json:"retry_backoff"`		// 首次重试前等待的时间(s)，之后每次翻倍，0表示使用任务类型的默认值
+	Attempt 				int 		`json:"attempt"`			// 第几次重试(首次执行为0)
+	RetryAt 				int64 		`json:"retry_at"`			// 重试的时间(ms)，到达之前不执行
+
+	// 执行时间窗口
+	NotBefore 				int64 		`json:"not_before"`			// 最早开始执行的时间(ms)，到达之前任务休眠，0表示不限制
+	Deadline 				int64 		`json:"deadline"`			// 最晚开始执行的时间(ms)，到达时还没有开始执行的任务过期失败，0表示不限制
+
+	// 定时任务
+	ScheduleId 				int64 		`json:"schedule_id"`		// 生成该任务的定时任务id(不是定时生成时为0)
+	ScheduleTime 			int64 		`json:"schedule_time"`		// 计划执行的时间(ms)
+
+	// 视频分段
+	ParentId 				int64 		`json:"parent_id"`			// 被切分的视频任务id(不是视频分段时为0)
+	SegmentIndex 			int 		`json:"segment_index"`		// 分段序号
+	SegmentStart 			float64 	`json:"segment_start"`		// 分段在视频中的开始时间(s)
+	SegmentEnd 				float64 	`json:"segment_end"`		// 分段在视频中的结束时间(s)，0表示到视频结尾
+}
+
+// 任务在now(ms)时是否休眠：还没到最早开始时间或重试时间
+func (This *Task) Dormant(now int64) bool {
+	return This.NotBefore > now || This.RetryAt > now
+}
+
+// 任务在now(ms)时是否已经过期：过了最晚开始时间还没有开始执行
+func (This *Task) Expired(now int64) bool {
+	return This.Deadline > 0 && This.Deadline <= now
 }
@@ -4,6 +4,13 @@ package common
 
 type TaskExecResult struct {
 	CurTaskExecStatus 					*TaskExecStatus 	// 任务执行状态信息
-	CurTaskOutput						[]byte          	// 任务标准输出
-	CurTaskError						error            	// 任务错误输出
+	CurTaskStdout						[]byte          	// 模型程序的标准输出
+	CurTaskStderr						[]byte          	// 模型程序的标准错误
+	CurTaskExitCode						int             	// 模型程序的退出码(-1表示没有启动或者被信号终止)
+	CurTaskSignal						string          	// 终止模型程序的信号
+	CurTaskKillSignal					string          	// 强杀或超时时worker向进程组最后发送的信号(SIGTERM, SIGKILL)
+	CurTaskError						error            	// 执行失败的原因
+	CurTaskResult						*CrackResult		// 解析后的识别结果
+	CurTaskArtifacts					[]*Artifact			// 保存的产物文件
+	CurTaskErrorClass					string				// 错误类别，决定失败后能否重试
 }
@@ -0,0 +1,10 @@
+package common
+
+// 任务的执行进度：模型程序通过进度管道(环境变量CRACK_PROGRESS_FD指定的文件描述符)逐行写入JSON，
+// worker解析后按间隔写入任务状态记录，如 {"progress":0.42,"stage":"detect","metrics":{"frames":120}}
+type TaskProgress struct {
+	Progress 				float64 			`bson:"progress" json:"progress"`			// 完成比例(0-1)
+	Stage 					string 				`bson:"stage" json:"stage"`					// 当前阶段的名称
+	Metrics 				map[string]float64 	`bson:"metrics" json:"metrics"`				// 中间指标
+	UpdateTime 				int64 				`bson:"update_time" json:"update_time"`		// 最近一次报告的时间(ms)
+}
@@ -0,0 +1,61 @@
+package common
+
+// 任务的生命周期状态
+type TaskState string
+
+const (
+	TaskPending 			TaskState = "pending"			// 已提交，等待worker抢占
+	TaskClaimed 			TaskState = "claimed"			// 已被某个worker抢占
+	TaskRunning 			TaskState = "running"			// 模型程序正在执行
+	TaskSucceeded 			TaskState = "succeeded"			// 执行成功
+	TaskFailed 				TaskState = "failed"			// 执行失败
+	TaskKilled 				TaskState = "killed"			// 被强杀
+	TaskTimedOut 			TaskState = "timed_out"			// 执行超时
+	TaskCancelled 			TaskState = "cancelled"			// 在被worker抢占之前被取消
+)
+
+// 合法的状态转换
+var taskTransitions = map[TaskState][]TaskState{
+	TaskPending: 	{TaskClaimed, TaskKilled, TaskCancelled},
+	TaskClaimed: 	{TaskRunning, TaskFailed, TaskKilled, TaskTimedOut, TaskPending},
+	TaskRunning: 	{TaskSucceeded, TaskFailed, TaskKilled, TaskTimedOut, TaskPending},
+}
+
+// 能否从当前状态转换到to
+func (This TaskState) CanTransitionTo(to TaskState) bool {
+	var (
+		next 				TaskState
+	)
+	for _, next = range taskTransitions[This] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否是最终状态
+func (This TaskState) IsFinal() bool {
+	return len(taskTransitions[This]) == 0
+}
+
+// 任务状态记录(etcd中StatusDir/任务id，同时镜像到MongoDB)
+type TaskStatus struct {
+	TaskType 					string 		`bson:"task_type" json:"task_type"`					// 任务类型(image, video)
+	UserId 						int64 		`bson:"user_id" json:"user_id"`						// 发布该任务的用户id
+	TaskName 					string		`bson:"task_name" json:"task_name"`         		// 任务名称
+	TaskId 						int64 		`bson:"task_id" json:"task_id"`						// 任务id
+
+	State 						TaskState 	`bson:"state" json:"state"`							// 当前状态
+	Worker 						string 		`bson:"worker" json:"worker"`						// 执行该任务的worker
+	Error 						string 		`bson:"error" json:"error"`							// 失败原因
+	Attempt 					int 		`bson:"attempt" json:"attempt"`						// 第几次重试(首次执行为0)
+	OutputRefs 					[]string 	`bson:"output_refs" json:"output_refs"`				// 执行成功后产生的输出文件的引用
+	SubmitTime 					int64 		`bson:"submit_time" json:"submit_time"`				// 提交时间
+	ClaimTime 					int64 		`bson:"claim_time" json:"claim_time"`				// 被抢占的时间
+	StartTime 					int64 		`bson:"start_time" json:"start_time"`				// 开始执行的时间
+	FinishTime 					int64 		`bson:"finish_time" json:"finish_time"`				// 结束时间
+	UpdateTime 					int64 		`bson:"update_time" json:"update_time"`				// 状态更新时间
+	Progress 					*TaskProgress `bson:"progress" json:"progress"`			// 执行中的进度(模型程序没有报告时为空)
+	Revision 					int64 		`bson:"revision" json:"revision"`					// 该状态在etcd中的修改版本号
+}
@@ -0,0 +1,26 @@
+package common
+
+// 任务执行时的实时输出：worker逐行收集模型程序的标准输出和标准错误，只保留最近的若干行，
+// 定期写入StreamDir/任务id，master通过Server-Sent Events推给正在跟踪该任务的客户端
+type TaskStream struct {
+	TaskId 					int64 			`json:"task_id"`				// 任务id
+	Attempt 				int 			`json:"attempt"`				// 第几次执行(每次执行的行号从1开始)
+	Worker 					string 			`json:"worker"`					// 执行该任务的worker
+	Lines 					[]*StreamLine 	`json:"lines"`					// 最近的输出行(行号递增，更早的行已被丢弃)
+	Done 					bool 			`json:"done"`					// 本次执行的输出已经结束
+	UpdateTime 				int64 			`json:"update_time"`			// 更新时间(ms)
+}
+
+// 一行输出
+type StreamLine struct {
+	Seq 					int64 			`json:"seq"`					// 行号
+	Stream 					string 			`json:"stream"`					// stdout, stderr
+	Text 					string 			`json:"text"`					// 内容(不含换行符)
+	Time 					int64 			`json:"time"`					// 输出时间(ms)
+}
+
+// 输出流的名称
+const (
+	StreamStdout 			string = "stdout"
+	StreamStderr 			string = "stderr"
+)
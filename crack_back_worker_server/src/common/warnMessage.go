@@ -8,5 +8,10 @@ type WarnMessage struct {
 	TaskId 						int64 		`bson:"task_id" json:"task_id"`					// 任务id
 
 	Message 					string		`bson:"message" json:"message"`					// 警告信息
+	ErrorClass 					string		`bson:"error_class" json:"error_class"`			// 错误类别(exit, timeout, result, input, output, expired, oom, pids)
+	ExitCode 					int			`bson:"exit_code" json:"exit_code"`				// 模型程序的退出码(-1表示没有启动或者被信号终止)
+	Signal 						string		`bson:"signal" json:"signal"`					// 终止模型程序的信号(如SIGKILL)
+	Stdout 						string		`bson:"stdout" json:"stdout"`					// 标准输出的最后一部分
+	Stderr 						string		`bson:"stderr" json:"stderr"`					// 标准错误的最后一部分
 	GenerateTime 				int64		`bson:"generate_time" json:"generate_time"`		// 信息产生时间
 }
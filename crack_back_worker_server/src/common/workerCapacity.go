@@ -0,0 +1,20 @@
+package common
+
+// worker的注册信息：执行容量和标签(worker注册时写入WorkersDir/IP的value，变化时更新)
+type WorkerCapacity struct {
+	Ip 						string 							`json:"ip"`					// worker的IP
+	MaxRunning 				int 							`json:"max_running"`		// 同时执行的任务数上限
+	Running 				int 							`json:"running"`			// 正在执行的任务数
+	Free 					int 							`json:"free"`				// 空闲的执行槽位
+	Queued 					int 							`json:"queued"`				// 等待执行的任务数
+	Types 					map[string]*TypeCapacity 		`json:"types"`				// 每种任务类型的容量
+	Labels 					map[string]string 				`json:"labels"`				// 标签(含自动生成的task_type)
+	UpdateTime 				int64 							`json:"update_time"`		// 更新时间
+}
+
+// 一种任务类型的执行容量
+type TypeCapacity struct {
+	MaxRunning 				int 							`json:"max_running"`		// 该类型同时执行的任务数上限(受worker的上限约束)
+	Running 				int 							`json:"running"`			// 该类型正在执行的任务数
+	Free 					int 							`json:"free"`				// 该类型空闲的执行槽位
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crack_back/src/common"
+	"errors"
 	"github.com/Unknwon/goconfig"
 	"strconv"
 	"strings"
@@ -25,15 +27,25 @@ type Config struct {
 	WarnDir				string
 	FinishDir			string
 	FailDir				string
+	StatusDir			string
+	AssignDir			string
+	StreamDir			string
 
 	// worker
 	WorkersDir			string
+	Labels				map[string]string
+	AssignMode			bool
+	CgroupRoot			string
+	LockSession			bool
+	SessionTTL			int64
 
 	// database
 	DatabaseURI			string
 	ConnectTimeOut		time.Duration
 	DatabaseName 		string
 	Collection 			string
+	ResultCollection	string
+	StatusCollection	string
 	BatchSize 			int
 	CommitInterval		time.Duration
 
@@ -42,6 +54,60 @@ type Config struct {
 	KafkaTimeout		time.Duration
 	WarnTopic			string
 	GroupName 			string
+
+	// blob
+	BlobBackend 		string
+	BlobLocalRoot 		string
+	BlobCacheDir 		string
+
+	// ftp
+	FtpAddr 			string
+	FtpUser 			string
+	FtpPassword 		string
+	FtpDir 				string
+	FtpTimeout 			time.Duration
+
+	// runner
+	Runners 			map[string]*RunnerConfig
+
+	// scheduler
+	AgingInterval 		time.Duration
+	FairShareWeight 	int
+	MaxConcurrency 		int
+
+	// stream
+	StreamInterval 		time.Duration
+	StreamBufferLines 	int
+	StreamMaxLineBytes 	int
+	StreamRetention 	int64
+	ProgressInterval 	time.Duration
+}
+
+// 某一任务类型的模型程序配置(config.ini中的[runner.任务类型])
+type RunnerConfig struct {
+	TaskType 			string
+	Interpreter 		string				// 解释器(为空时直接执行Script)
+	Script 				string				// 模型程序路径
+	Args 				[]string			// 参数模板
+	Env 				[]string			// 额外的环境变量 KEY=VALUE
+	WorkDir 			string				// 工作目录
+	Timeout 			time.Duration		// 任务未指定超时时间时的默认超时时间
+	ParseResult 		bool				// 是否从标准输出中解析裂缝识别结果(流水线中的预处理等步骤没有识别结果)
+	MaxConcurrency 		int					// 该任务类型同时执行的任务数上限(0表示只受worker的上限约束)
+	KillGrace 			time.Duration		// 强杀或超时时发送SIGTERM后等待进程组退出的时间，超过后发送SIGKILL
+	MemoryLimit 		int64				// 内存限额(字节，0表示不限制)
+	CpuLimit 			float64				// CPU限额(核数，0表示不限制)
+	PidsLimit 			int					// 进程数限额(0表示不限制)
+	Artifacts 			[]string			// 需要保存的产物文件(相对于工作目录的通配符)
+	Sandbox 			bool				// 是否在沙箱中执行
+	SandboxUid 			int					// 沙箱中执行模型程序的用户
+	SandboxGid 			int
+	SandboxTmpSize 		int					// 沙箱中/tmp的大小(MB)
+
+	MaxRetries 			int					// 任务未指定时的最大重试次数
+	RetryBackoff 		time.Duration		// 任务未指定时首次重试前等待的时间
+	RetryMaxBackoff 	time.Duration		// 重试等待时间的上限
+	RetryOn 			[]string			// 可以重试的错误类别
 }
 
 // 配置的单例
@@ -86,6 +152,26 @@ func InitConfig(configFile string) (err error) {
 			return err
 		}
 
+		if err = initBlobConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initFtpConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initRunnerConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initSchedulerConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initStreamConfig(cf, &config); err != nil{
+			return err
+		}
+
 		Cfg = &config
 	}
 	return nil
@@ -144,6 +230,9 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 		warnDir				string
 		finishDir			string
 		failDir				string
+		statusDir			string
+		assignDir			string
+		streamDir			string
 	)
 
 	if baseDir, err = cf.GetValue("task", "TaskDir"); err != nil{
@@ -164,6 +253,15 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	if failDir, err = cf.GetValue("task", "FailDir"); err != nil{
 		return err
 	}
+	if statusDir, err = cf.GetValue("task", "StatusDir"); err != nil{
+		return err
+	}
+	if assignDir, err = cf.GetValue("task", "AssignDir"); err != nil{
+		return err
+	}
+	if streamDir, err = cf.GetValue("task", "StreamDir"); err != nil{
+		return err
+	}
 
 	config.TaskDir = baseDir
 	config.KillerDir = killerDir
@@ -171,6 +269,9 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	config.WarnDir = warnDir
 	config.FinishDir = finishDir
 	config.FailDir = failDir
+	config.StatusDir = statusDir
+	config.AssignDir = assignDir
+	config.StreamDir = streamDir
 
 	return nil
 }
@@ -179,13 +280,27 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 func initWorkerConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	var(
 		workersDir			string
+		sessionTTLStr		string
+		sessionTTL			int
 	)
 
 	if workersDir, err = cf.GetValue("worker", "WorkersDir"); err != nil{
 		return err
 	}
+	sessionTTLStr = cf.MustValue("worker", "SessionTTL", "10")
+
+	if sessionTTL, err = strconv.Atoi(sessionTTLStr); err != nil{
+		return err
+	}
 
 	config.WorkersDir = workersDir
+	if config.Labels, err = common.ParseLabels(cf.MustValue("worker", "Labels", "")); err != nil{
+		return err
+	}
+	config.AssignMode = cf.MustValue("worker", "Mode", "race") == "assign"
+	config.CgroupRoot = cf.MustValue("worker", "CgroupRoot", "")
+	config.LockSession = cf.MustValue("worker", "LockMode", "task") == "session"
+	config.SessionTTL = int64(sessionTTL)
 
 	return nil
 }
@@ -198,6 +313,8 @@ func initMongoDBConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 		connectTimeOut			int
 		dbName					string
 		collection 				string
+		resultCollection		string
+		statusCollection		string
 		batchSizeStr			string
 		batchSize 				int
 		commitIntervalStr		string
@@ -216,6 +333,12 @@ func initMongoDBConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	if collection, err = cf.GetValue("MongoDB", "Collection"); err != nil{
 		return err
 	}
+	if resultCollection, err = cf.GetValue("MongoDB", "ResultCollection"); err != nil{
+		return err
+	}
+	if statusCollection, err = cf.GetValue("MongoDB", "StatusCollection"); err != nil{
+		return err
+	}
 	if batchSizeStr, err = cf.GetValue("MongoDB", "BatchSize"); err != nil{
 		return err
 	}
@@ -237,6 +360,8 @@ func initMongoDBConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	config.ConnectTimeOut = time.Duration(connectTimeOut)*time.Millisecond
 	config.DatabaseName = dbName
 	config.Collection = collection
+	config.ResultCollection = resultCollection
+	config.StatusCollection = statusCollection
 	config.BatchSize = batchSize
 	if config.BatchSize < 1{
 		config.BatchSize = 1
@@ -285,3 +410,279 @@ func initKafkaConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 
 	return nil
 }
+
+// 初始化blob存储配置
+func initBlobConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		backend 					string
+		localRoot 					string
+		cacheDir 					string
+	)
+
+	if backend, err = cf.GetValue("blob", "Backend"); err != nil{
+		return err
+	}
+	if localRoot, err = cf.GetValue("blob", "LocalRoot"); err != nil{
+		return err
+	}
+	if cacheDir, err = cf.GetValue("blob", "CacheDir"); err != nil{
+		return err
+	}
+
+	config.BlobBackend = strings.ToLower(backend)
+	config.BlobLocalRoot = localRoot
+	config.BlobCacheDir = cacheDir
+
+	return nil
+}
+
+// 初始化ftp配置
+func initFtpConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		ip 							string
+		port 						string
+		user 						string
+		password 					string
+		dir 						string
+		timeoutStr					string
+		timeout						int
+	)
+
+	if ip, err = cf.GetValue("ftp", "Ip"); err != nil{
+		return err
+	}
+	if port, err = cf.GetValue("ftp", "Port"); err != nil{
+		return err
+	}
+	if user, err = cf.GetValue("ftp", "User"); err != nil{
+		return err
+	}
+	if password, err = cf.GetValue("ftp", "Password"); err != nil{
+		return err
+	}
+	if dir, err = cf.GetValue("ftp", "Dir"); err != nil{
+		return err
+	}
+	if timeoutStr, err = cf.GetValue("ftp", "Timeout"); err != nil{
+		return err
+	}
+
+	if _, err = strconv.Atoi(port); err != nil{
+		return err
+	}
+	if timeout, err = strconv.Atoi(timeoutStr); err != nil{
+		return err
+	}
+
+	config.FtpAddr = ip + ":" + port
+	config.FtpUser = user
+	config.FtpPassword = password
+	config.FtpDir = dir
+	config.FtpTimeout = time.Duration(timeout)*time.Millisecond
+
+	return nil
+}
+
+// 初始化模型程序配置，每个[runner.任务类型]对应一种任务类型
+func initRunnerConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		section 					string
+		runner 						*RunnerConfig
+		args 						string
+		env 						string
+		timeoutStr					string
+		timeout						int
+		retryOn 					string
+		artifacts 					string
+		maxConcurrencyStr			string
+		killGraceStr				string
+		killGrace					int
+		memoryLimitStr				string
+		memoryLimit					int64
+		cpuLimitStr					string
+		pidsLimitStr				string
+		sandboxStr					string
+		sandboxUidStr				string
+		sandboxGidStr				string
+		sandboxTmpSizeStr			string
+		maxRetriesStr				string
+		retryBackoffStr				string
+		retryBackoff				int
+		retryMaxBackoffStr			string
+		retryMaxBackoff				int
+	)
+
+	config.Runners = make(map[string]*RunnerConfig)
+	for _, section = range cf.GetSectionList() {
+		if !strings.HasPrefix(section, "runner.") {
+			continue
+		}
+		runner = &RunnerConfig{
+			TaskType: strings.TrimPrefix(section, "runner."),
+		}
+
+		if runner.Script, err = cf.GetValue(section, "Script"); err != nil{
+			return err
+		}
+		runner.Interpreter = cf.MustValue(section, "Interpreter", "")
+		args = cf.MustValue(section, "Args", "{script} {input}")
+		env = cf.MustValue(section, "Env", "")
+		runner.WorkDir = cf.MustValue(section, "WorkDir", "")
+		runner.ParseResult = cf.MustValue(section, "Result", "crack") == "crack"
+		maxConcurrencyStr = cf.MustValue(section, "MaxConcurrency", "0")
+		killGraceStr = cf.MustValue(section, "KillGrace", "10")
+		memoryLimitStr = cf.MustValue(section, "MemoryLimit", "0")
+		cpuLimitStr = cf.MustValue(section, "CpuLimit", "0")
+		pidsLimitStr = cf.MustValue(section, "PidsLimit", "0")
+		artifacts = cf.MustValue(section, "Artifacts", "")
+		sandboxStr = cf.MustValue(section, "Sandbox", "false")
+		sandboxUidStr = cf.MustValue(section, "SandboxUid", "0")
+		sandboxGidStr = cf.MustValue(section, "SandboxGid", "0")
+		sandboxTmpSizeStr = cf.MustValue(section, "SandboxTmpSize", "256")
+		timeoutStr = cf.MustValue(section, "Timeout", "0")
+		// 重试策略
+		maxRetriesStr = cf.MustValue(section, "MaxRetries", "0")
+		retryBackoffStr = cf.MustValue(section, "RetryBackoff", "5")
+		retryMaxBackoffStr = cf.MustValue(section, "RetryMaxBackoff", "300")
+		retryOn = cf.MustValue(section, "RetryOn", "exit,input")
+
+		if runner.MaxConcurrency, err = strconv.Atoi(maxConcurrencyStr); err != nil{
+			return err
+		}
+		if killGrace, err = strconv.Atoi(killGraceStr); err != nil{
+			return err
+		}
+		if memoryLimit, err = strconv.ParseInt(memoryLimitStr, 10, 64); err != nil{
+			return err
+		}
+		if runner.CpuLimit, err = strconv.ParseFloat(cpuLimitStr, 64); err != nil{
+			return err
+		}
+		if runner.PidsLimit, err = strconv.Atoi(pidsLimitStr); err != nil{
+			return err
+		}
+		if runner.Sandbox, err = strconv.ParseBool(sandboxStr); err != nil{
+			return err
+		}
+		if runner.SandboxUid, err = strconv.Atoi(sandboxUidStr); err != nil{
+			return err
+		}
+		if runner.SandboxGid, err = strconv.Atoi(sandboxGidStr); err != nil{
+			return err
+		}
+		if runner.SandboxTmpSize, err = strconv.Atoi(sandboxTmpSizeStr); err != nil{
+			return err
+		}
+		if timeout, err = strconv.Atoi(timeoutStr); err != nil{
+			return err
+		}
+		if runner.MaxRetries, err = strconv.Atoi(maxRetriesStr); err != nil{
+			return err
+		}
+		if retryBackoff, err = strconv.Atoi(retryBackoffStr); err != nil{
+			return err
+		}
+		if retryMaxBackoff, err = strconv.Atoi(retryMaxBackoffStr); err != nil{
+			return err
+		}
+
+		runner.KillGrace = time.Duration(killGrace)*time.Second
+		runner.MemoryLimit = memoryLimit*1024*1024
+		if artifacts != "" {
+			runner.Artifacts = strings.Split(artifacts, ",")
+		}
+		runner.RetryBackoff = time.Duration(retryBackoff)*time.Second
+		runner.RetryMaxBackoff = time.Duration(retryMaxBackoff)*time.Second
+		if retryOn != "" {
+			runner.RetryOn = strings.Split(retryOn, ",")
+		}
+
+		runner.Args = strings.Fields(args)
+		if env != "" {
+			runner.Env = strings.Split(env, ",")
+		}
+		runner.Timeout = time.Duration(timeout)*time.Second
+
+		config.Runners[runner.TaskType] = runner
+	}
+
+	return nil
+}
+
+// 初始化调度队列配置
+func initSchedulerConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		agingIntervalStr			string
+		agingInterval				int
+		fairShareWeightStr			string
+		fairShareWeight				int
+		maxConcurrencyStr			string
+	)
+
+	if agingIntervalStr, err = cf.GetValue("scheduler", "AgingInterval"); err != nil{
+		return err
+	}
+	if fairShareWeightStr, err = cf.GetValue("scheduler", "FairShareWeight"); err != nil{
+		return err
+	}
+	maxConcurrencyStr = cf.MustValue("scheduler", "MaxConcurrency", "0")
+
+	if agingInterval, err = strconv.Atoi(agingIntervalStr); err != nil{
+		return err
+	}
+	if fairShareWeight, err = strconv.Atoi(fairShareWeightStr); err != nil{
+		return err
+	}
+	if config.MaxConcurrency, err = strconv.Atoi(maxConcurrencyStr); err != nil{
+		return err
+	}
+
+	config.AgingInterval = time.Duration(agingInterval)*time.Second
+	config.FairShareWeight = fairShareWeight
+
+	return nil
+}
+
+// 初始化实时输出配置
+func initStreamConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		intervalStr					string
+		interval					int
+		bufferLinesStr				string
+		maxLineBytesStr				string
+		retentionStr				string
+		progressIntervalStr			string
+		progressInterval			int
+	)
+
+	intervalStr = cf.MustValue("stream", "Interval", "500")
+	bufferLinesStr = cf.MustValue("stream", "BufferLines", "200")
+	maxLineBytesStr = cf.MustValue("stream", "MaxLineBytes", "4096")
+	retentionStr = cf.MustValue("stream", "Retention", "60")
+	progressIntervalStr = cf.MustValue("stream", "ProgressInterval", "2000")
+
+	if interval, err = strconv.Atoi(intervalStr); err != nil{
+		return err
+	}
+	if config.StreamBufferLines, err = strconv.Atoi(bufferLinesStr); err != nil{
+		return err
+	}
+	if config.StreamMaxLineBytes, err = strconv.Atoi(maxLineBytesStr); err != nil{
+		return err
+	}
+	if config.StreamRetention, err = strconv.ParseInt(retentionStr, 10, 64); err != nil{
+		return err
+	}
+	if progressInterval, err = strconv.Atoi(progressIntervalStr); err != nil{
+		return err
+	}
+
+	config.StreamInterval = time.Duration(interval)*time.Millisecond
+	config.ProgressInterval = time.Duration(progressInterval)*time.Millisecond
+
+	if config.StreamInterval <= 0 || config.StreamBufferLines <= 0 || config.StreamMaxLineBytes <= 0 || config.StreamRetention <= 0 ||
+		config.ProgressInterval <= 0 {
+		return errors.New("[stream]的配置必须大于0")
+	}
+	return nil
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"crack_back/src/config"
 	"crack_back/src/worker/alerter"
+	"crack_back/src/worker/blobStore"
 	"crack_back/src/worker/logger"
 	"crack_back/src/worker/notifier"
 	"crack_back/src/worker/register"
+	"crack_back/src/worker/runner"
+	"crack_back/src/worker/statusManager"
+	"crack_back/src/worker/streamer"
 	"crack_back/src/worker/taskLogger"
 	"crack_back/src/worker/taskManager"
 	"flag"
@@ -36,6 +40,11 @@ func main()  {
 		forceQuit				chan os.Signal
 	)
 
+	// 沙箱初始化进程(由执行器重新执行worker本身)，不会返回
+	if runner.IsSandboxInit() {
+		runner.SandboxInit()
+	}
+
 	// 解析命令行参数
 	flag.StringVar(&configFile, "config", "/home/gys/go/src/crack_back_worker_server/src/config/config.ini", "指定配置文件路径")
 	flag.Parse()
@@ -63,6 +72,22 @@ func main()  {
 	}
 	logger.Logger.InfoLog("crack_back初始化任务管理器成功")
 
+	// 初始化文件存储器
+	if err = blobStore.InitBlobStore(); err != nil{
+		fmt.Println("crack_back初始化文件存储器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_back初始化文件存储器成功")
+
+	// 初始化模型程序注册表
+	if err = runner.InitRegistry(); err != nil{
+		fmt.Println("crack_back初始化模型程序注册表错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_back初始化模型程序注册表成功")
+
 	// 初始化任务日志记录器
 	if err = taskLogger.InitLogger(); err != nil{
 		fmt.Println("crack_back初始化日志记录器错误:", err)
@@ -79,6 +104,22 @@ func main()  {
 	}
 	logger.Logger.InfoLog("crack_back初始化服务注册器成功")
 
+	// 初始化任务状态管理器
+	if err = statusManager.InitStatusManager(); err != nil{
+		fmt.Println("crack_back初始化任务状态管理器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_back初始化任务状态管理器成功")
+
+	// 初始化实时输出发布器
+	if err = streamer.InitStreamer(); err != nil{
+		fmt.Println("crack_back初始化实时输出发布器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_back初始化实时输出发布器成功")
+
 	// 初始化警报器
 	if err = alerter.InitAlerter(); err != nil{
 		fmt.Println("crack_back初始化警报器错误:", err)
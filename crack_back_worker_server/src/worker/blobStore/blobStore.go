@@ -0,0 +1,64 @@
+package blobStore
+
+import (
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"io"
+	"strings"
+)
+
+// 待识别文件的存储器。任务中只携带master保存文件时生成的引用(scheme://name)
+// 执行任务前由存储器把引用解析为本地文件路径，任务产生的文件(流水线步骤的输出)也存入同一个存储器供下游使用
+
+type BlobStore interface {
+	// 获取引用对应的本地文件路径
+	Fetch(ref string) (localPath string, err error)
+	// 任务执行完毕后释放Fetch得到的本地文件
+	Release(localPath string)
+	// 保存任务产生的文件，返回文件的引用
+	Put(name string, reader io.Reader) (ref string, err error)
+}
+
+// 存储后端
+const (
+	LocalBackend			=			"local"
+	FtpBackend				=			"ftp"
+)
+
+// 生成文件引用
+func newRef(backend string, name string) string {
+	return backend + "://" + strings.TrimPrefix(name, "/")
+}
+
+// 解析文件引用，backend必须与当前存储后端一致
+func parseRef(ref string, backend string) (name string, err error) {
+	if !strings.HasPrefix(ref, backend + "://") {
+		return "", common.ERROR_BLOB_REF
+	}
+	name = strings.TrimPrefix(ref, backend + "://")
+	// 不允许跳出存储根目录
+	if len(name) == 0 || strings.Contains(name, "..") {
+		return "", common.ERROR_BLOB_REF
+	}
+	return
+}
+
+// 存储器单例
+var (
+	Store			BlobStore
+)
+
+// 初始化存储器
+func InitBlobStore() (err error) {
+	if Store == nil {
+		switch config.Cfg.BlobBackend {
+		case LocalBackend:
+			Store, err = newLocalStore(config.Cfg.BlobLocalRoot)
+		case FtpBackend:
+			Store, err = newFtpStore(config.Cfg.FtpAddr, config.Cfg.FtpUser, config.Cfg.FtpPassword, config.Cfg.FtpDir, config.Cfg.FtpTimeout, config.Cfg.BlobCacheDir)
+		default:
+			err = common.ERROR_BLOB_BACKEND
+		}
+	}
+	return
+}
@@ -0,0 +1,190 @@
+package blobStore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一个极简的ftp客户端(被动模式 + 二进制传输)，只实现存储器需要的命令
+
+type ftpConn struct {
+	conn 				net.Conn
+	text 				*textproto.Conn
+	host 				string
+	timeout 			time.Duration
+}
+
+// 连接并登录ftp服务器
+func dialFtp(addr string, user string, password string, timeout time.Duration) (c *ftpConn, err error) {
+	var (
+		conn 				net.Conn
+		host 				string
+		code 				int
+	)
+	if host, _, err = net.SplitHostPort(addr); err != nil {
+		return
+	}
+	if conn, err = net.DialTimeout("tcp", addr, timeout); err != nil {
+		return
+	}
+	c = &ftpConn{
+		conn:    conn,
+		text:    textproto.NewConn(conn),
+		host:    host,
+		timeout: timeout,
+	}
+
+	// 欢迎信息
+	if _, _, err = c.text.ReadResponse(220); err != nil {
+		goto FAIL
+	}
+
+	// 登录(没有密码的账号直接返回230)
+	if code, _, err = c.cmd(0, "USER %s", user); err != nil {
+		goto FAIL
+	}
+	if code == 331 {
+		if _, _, err = c.cmd(230, "PASS %s", password); err != nil {
+			goto FAIL
+		}
+	} else if code != 230 {
+		err = fmt.Errorf("ftp登录失败: %d", code)
+		goto FAIL
+	}
+
+	// 二进制模式
+	if _, _, err = c.cmd(200, "TYPE I"); err != nil {
+		goto FAIL
+	}
+	return c, nil
+
+FAIL:
+	_ = c.text.Close()
+	return nil, err
+}
+
+// 发送一条命令并读取应答，expectCode为0时不校验应答码
+func (This *ftpConn) cmd(expectCode int, format string, args ...interface{}) (code int, message string, err error) {
+	_ = This.conn.SetDeadline(time.Now().Add(This.timeout))
+	if _, err = This.text.Cmd(format, args...); err != nil {
+		return
+	}
+	code, message, err = This.text.ReadResponse(expectCode)
+	return
+}
+
+// 进入被动模式，返回数据连接
+func (This *ftpConn) pasv() (dataConn net.Conn, err error) {
+	var (
+		message 			string
+		start 				int
+		end 				int
+		fields 				[]string
+		p1 					int
+		p2 					int
+	)
+	if _, message, err = This.cmd(227, "PASV"); err != nil {
+		return
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start = strings.Index(message, "(")
+	end = strings.LastIndex(message, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("无法解析PASV应答: " + message)
+	}
+	if fields = strings.Split(message[start+1:end], ","); len(fields) != 6 {
+		return nil, errors.New("无法解析PASV应答: " + message)
+	}
+	if p1, err = strconv.Atoi(strings.TrimSpace(fields[4])); err != nil {
+		return
+	}
+	if p2, err = strconv.Atoi(strings.TrimSpace(fields[5])); err != nil {
+		return
+	}
+	// 忽略应答中的地址，直接使用控制连接的地址(服务器在NAT后面时应答中的地址不可用)
+	return net.DialTimeout("tcp", net.JoinHostPort(This.host, strconv.Itoa(p1<<8+p2)), This.timeout)
+}
+
+// 下载文件到writer
+func (This *ftpConn) retr(filePath string, writer io.Writer) (err error) {
+	var (
+		dataConn 			net.Conn
+	)
+	if dataConn, err = This.pasv(); err != nil {
+		return
+	}
+	if _, _, err = This.cmd(1, "RETR %s", filePath); err != nil {
+		_ = dataConn.Close()
+		return
+	}
+	_, err = io.Copy(writer, dataConn)
+	_ = dataConn.Close()
+	if err != nil {
+		return
+	}
+	// 226 Transfer complete
+	_ = This.conn.SetDeadline(time.Now().Add(This.timeout))
+	_, _, err = This.text.ReadResponse(2)
+	return
+}
+
+// 逐级创建目录，目录已存在时服务器返回550，忽略即可
+func (This *ftpConn) mkdirAll(dir string) {
+	var (
+		cur 				string
+		part 				string
+	)
+	for _, part = range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		cur = cur + "/" + part
+		_, _, _ = This.cmd(0, "MKD %s", cur)
+	}
+}
+
+// 上传文件
+func (This *ftpConn) stor(filePath string, reader io.Reader) (err error) {
+	var (
+		dataConn 			net.Conn
+	)
+	if dataConn, err = This.pasv(); err != nil {
+		return
+	}
+	if _, _, err = This.cmd(1, "STOR %s", filePath); err != nil {
+		_ = dataConn.Close()
+		return
+	}
+	if _, err = io.Copy(dataConn, reader); err != nil {
+		_ = dataConn.Close()
+		return
+	}
+	if err = dataConn.Close(); err != nil {
+		return
+	}
+	// 226 Transfer complete
+	_ = This.conn.SetDeadline(time.Now().Add(This.timeout))
+	_, _, err = This.text.ReadResponse(2)
+	return
+}
+
+// 改名
+func (This *ftpConn) rename(from string, to string) (err error) {
+	if _, _, err = This.cmd(350, "RNFR %s", from); err != nil {
+		return
+	}
+	_, _, err = This.cmd(250, "RNTO %s", to)
+	return
+}
+
+// 退出并关闭连接
+func (This *ftpConn) quit() {
+	_, _, _ = This.cmd(0, "QUIT")
+	_ = This.text.Close()
+}
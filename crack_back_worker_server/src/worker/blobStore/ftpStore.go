@@ -0,0 +1,111 @@
+package blobStore
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ftp服务器存储，执行任务前把文件下载到本地缓存目录，执行完毕后删除
+type ftpStore struct {
+	addr 				string
+	user 				string
+	password 			string
+	dir 				string
+	timeout 			time.Duration
+	cacheDir 			string
+}
+
+func newFtpStore(addr string, user string, password string, dir string, timeout time.Duration, cacheDir string) (store *ftpStore, err error) {
+	var (
+		conn 				*ftpConn
+	)
+	if err = os.MkdirAll(cacheDir, 0755); err != nil {
+		return
+	}
+	// 检查ftp服务器是否可用
+	if conn, err = dialFtp(addr, user, password, timeout); err != nil {
+		return
+	}
+	conn.quit()
+
+	store = &ftpStore{
+		addr:     addr,
+		user:     user,
+		password: password,
+		dir:      dir,
+		timeout:  timeout,
+		cacheDir: cacheDir,
+	}
+	return
+}
+
+func (This *ftpStore) Fetch(ref string) (localPath string, err error) {
+	var (
+		name 				string
+		conn 				*ftpConn
+		fp 					*os.File
+	)
+	if name, err = parseRef(ref, FtpBackend); err != nil {
+		return
+	}
+
+	// 同一个文件可能同时被多个任务使用，缓存文件名加上时间戳
+	localPath = filepath.Join(This.cacheDir, strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + path.Base(name))
+	if fp, err = os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return "", err
+	}
+
+	if conn, err = dialFtp(This.addr, This.user, This.password, This.timeout); err != nil {
+		goto FAIL
+	}
+	err = conn.retr(path.Join(This.dir, name), fp)
+	conn.quit()
+	if err != nil {
+		goto FAIL
+	}
+	if err = fp.Close(); err != nil {
+		_ = os.Remove(localPath)
+		return "", err
+	}
+	return localPath, nil
+
+FAIL:
+	_ = fp.Close()
+	_ = os.Remove(localPath)
+	return "", err
+}
+
+func (This *ftpStore) Release(localPath string) {
+	_ = os.Remove(localPath)
+}
+
+func (This *ftpStore) Put(name string, reader io.Reader) (ref string, err error) {
+	var (
+		conn 				*ftpConn
+		filePath 			string
+	)
+	ref = newRef(FtpBackend, name)
+	if name, err = parseRef(ref, FtpBackend); err != nil {
+		return "", err
+	}
+	filePath = path.Join(This.dir, name)
+
+	if conn, err = dialFtp(This.addr, This.user, This.password, This.timeout); err != nil {
+		return "", err
+	}
+	defer conn.quit()
+
+	conn.mkdirAll(path.Dir(filePath))
+	// 先上传临时文件再改名，防止读到写了一半的文件
+	if err = conn.stor(filePath+".uploading", reader); err != nil {
+		return "", err
+	}
+	if err = conn.rename(filePath+".uploading", filePath); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
@@ -0,0 +1,77 @@
+package blobStore
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// 本地文件系统存储(与master挂载同一个共享目录)，引用直接映射为根目录下的文件
+type localStore struct {
+	root 				string
+}
+
+func newLocalStore(root string) (store *localStore, err error) {
+	if _, err = os.Stat(root); err != nil {
+		return
+	}
+	store = &localStore{
+		root: root,
+	}
+	return
+}
+
+func (This *localStore) Fetch(ref string) (localPath string, err error) {
+	var (
+		name 				string
+	)
+	if name, err = parseRef(ref, LocalBackend); err != nil {
+		return
+	}
+	localPath = filepath.Join(This.root, filepath.FromSlash(path.Clean(name)))
+	if _, err = os.Stat(localPath); err != nil {
+		return "", err
+	}
+	return
+}
+
+// 文件属于共享目录，不删除
+func (This *localStore) Release(localPath string) {
+}
+
+func (This *localStore) Put(name string, reader io.Reader) (ref string, err error) {
+	var (
+		filePath 			string
+		tmpPath 			string
+		fp 					*os.File
+	)
+	ref = newRef(LocalBackend, name)
+	if name, err = parseRef(ref, LocalBackend); err != nil {
+		return "", err
+	}
+	filePath = filepath.Join(This.root, filepath.FromSlash(path.Clean(name)))
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+
+	// 先写临时文件再改名，防止读到写了一半的文件
+	tmpPath = filePath + ".uploading"
+	if fp, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(fp, reader); err != nil {
+		_ = fp.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err = fp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return ref, nil
+}
@@ -1,12 +1,24 @@
 package executor
 
 import (
+	"bytes"
 	"context"
 	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/blobStore"
 	"crack_back/src/worker/lock"
+	"crack_back/src/worker/logger"
+	"crack_back/src/worker/runner"
+	"crack_back/src/worker/statusManager"
+	"crack_back/src/worker/streamer"
+	"io"
+	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -22,45 +34,198 @@ func (This *Executor) ExecTask (taskExecStatus *common.TaskExecStatus, callback
 	go func() {
 		var (
 			err						error
-			output					[]byte
+			exitCode				int
+			signal					string
+			killSignal				string
+			limitErr				error
+			limitClass				string
+			artifacts				[]*common.Artifact
+			artifactErr				error
+			stdout					bytes.Buffer
+			stderr					bytes.Buffer
+			stdoutWriter			*streamer.LineWriter
+			stderrWriter			*streamer.LineWriter
+			progressReader			*streamer.ProgressReader
+			result					*common.CrackResult
 			taskExecResult			*common.TaskExecResult
 
 			cmd						*exec.Cmd
 			task					*common.Task
 			userTask				string
 			taskLock 				*lock.TaskLock
+			runDone					chan struct{}
+			paths					*runner.RunPaths
+			inputRef				string
+			inputPath				string
+			taskRunner				*runner.Runner
+			errClass				string
 		)
 		task = taskExecStatus.CurTask
+		exitCode = -1
 		userTask = path.Join(path.Join(task.TaskType, strconv.Itoa(int(task.UserId)), task.TaskName))
 
+		// 该worker没有配置此任务类型的模型程序时不抢锁，留给其他worker
+		if taskRunner, err = runner.Runners.Lookup(task.TaskType); err != nil{
+			goto CREATE_EXEC_RESULT
+		}
+
 		// 分布式锁
 		taskLock = lock.NewLock(userTask)
 		if err = taskLock.TryLock(); err != nil{
 			goto CREATE_EXEC_RESULT
 		}
+		// 锁的租约失效后其他worker可能已经抢到了该任务，取消本次执行
+		runDone = make(chan struct{})
+		defer close(runDone)
+		go func() {
+			select {
+			case <-taskLock.Lost():
+				logger.Logger.WarnLog(userTask, "lock lost, abandon the run")
+				taskExecStatus.DoCancelFunc()
+			case <-runDone:
+			}
+		}()
+		// 任务已经结束或者被强杀时不能再被抢占(例如worker重启后重放了旧任务)
+		if err = statusManager.SM.Transition(task, common.TaskClaimed, nil); err == common.ERROR_STATE_TRANSITION{
+			goto CREATE_EXEC_RESULT
+		}else if err != nil{
+			logger.Logger.WarnLog(userTask, "update status failed, err=", err)
+		}
 
-		// 新建cmd调用python程序
-		cmd = exec.CommandContext(taskExecStatus.CancelCtx, "python", "-c", taskExecStatus.CurTask.TaskName)
+		// 过了最晚开始时间的任务不再执行，直接过期失败
+		if task.Expired(time.Now().UnixNano() / 1000 / 1000) {
+			err = common.ERROR_TASK_EXPIRED
+			errClass = common.ErrorClassExpired
+			goto CREATE_EXEC_RESULT
+		}
+
+		// 取得待识别文件(以及上游步骤的输出文件)的本地路径
+		paths = &runner.RunPaths{}
+		if paths.Input, err = blobStore.Store.Fetch(task.InputRef); err != nil{
+			errClass = common.ErrorClassInput
+			goto CREATE_EXEC_RESULT
+		}
+		defer blobStore.Store.Release(paths.Input)
+		for _, inputRef = range task.InputRefs {
+			if inputPath, err = blobStore.Store.Fetch(inputRef); err != nil{
+				errClass = common.ErrorClassInput
+				goto CREATE_EXEC_RESULT
+			}
+			defer blobStore.Store.Release(inputPath)
+			paths.Inputs = append(paths.Inputs, inputPath)
+		}
+
+		// 本次执行的输出目录
+		paths.OutputDir = filepath.Join(config.Cfg.BlobCacheDir, "output", strconv.FormatInt(task.TaskId, 10) + "_" + strconv.Itoa(task.Attempt))
+		if err = os.MkdirAll(paths.OutputDir, 0755); err != nil{
+			errClass = common.ErrorClassOutput
+			goto CREATE_EXEC_RESULT
+		}
+		defer os.RemoveAll(paths.OutputDir)
+
+		// 本次执行独立的工作目录，模型程序把产物文件写在这里，执行结束后删除
+		paths.WorkDir = filepath.Join(config.Cfg.BlobCacheDir, "run", strconv.FormatInt(task.TaskId, 10) + "_" + strconv.Itoa(task.Attempt))
+		if err = os.MkdirAll(paths.WorkDir, 0755); err != nil{
+			errClass = common.ErrorClassOutput
+			goto CREATE_EXEC_RESULT
+		}
+		defer os.RemoveAll(paths.WorkDir)
+
+		// 根据模型程序配置新建cmd
+		if cmd, err = taskRunner.BuildCommand(task, paths); err != nil{
+			errClass = common.ErrorClassExit
+			goto CREATE_EXEC_RESULT
+		}
+
+		// 标准输出用于解析识别结果，需要和标准错误分开收集，同时逐行发布供客户端实时查看
+		stdoutWriter = streamer.Stream.NewLineWriter(task, common.StreamStdout)
+		stderrWriter = streamer.Stream.NewLineWriter(task, common.StreamStderr)
+		cmd.Stdout = io.MultiWriter(&stdout, stdoutWriter)
+		cmd.Stderr = io.MultiWriter(&stderr, stderrWriter)
+		streamer.Stream.Open(task)
+		// 进度管道创建失败时不影响执行，只是没有进度
+		if progressReader, err = streamer.OpenProgress(task, cmd); err != nil{
+			logger.Logger.WarnLog(userTask, "open progress pipe failed, err=", err)
+		}
+
+		if err = statusManager.SM.Transition(task, common.TaskRunning, nil); err != nil{
+			logger.Logger.WarnLog(userTask, "update status failed, err=", err)
+		}
 
 		taskExecStatus.ExecTime = time.Now()
 		// 执行cmd
-		output, err = cmd.CombinedOutput()
+		killSignal, limitErr, limitClass, err = taskRunner.Run(taskExecStatus.CancelCtx, cmd,
+			"task_" + strconv.FormatInt(task.TaskId, 10) + "_" + strconv.Itoa(task.Attempt))
 		taskExecStatus.FinishTime = time.Now()
+		stdoutWriter.Flush()
+		stderrWriter.Flush()
+		streamer.Stream.Close(task)
+		if progressReader != nil{
+			progressReader.Close()
+		}
+		exitCode, signal = exitStatus(cmd.ProcessState)
+		// 锁已经失效时不再解析结果和保存文件
+		if taskLock.IsLost(){
+			goto CREATE_EXEC_RESULT
+		}
+
+		// 正常退出时解析识别结果，并保存输出目录中的文件。超出资源限额时报告为对应的错误
+		if limitErr != nil{
+			err = limitErr
+			errClass = limitClass
+		}else if err != nil{
+			errClass = common.ErrorClassExit
+		}else if taskRunner.ParseResult(){
+			if result, err = common.ParseCrackResult(stdout.Bytes()); err != nil{
+				errClass = common.ErrorClassResult
+			}
+		}
+		if err == nil{
+			if task.OutputRefs, err = saveOutputs(task, paths.OutputDir); err != nil{
+				errClass = common.ErrorClassOutput
+			}
+		}
+		// 失败的执行也保存产物文件，便于排查
+		if artifacts, artifactErr = saveArtifacts(task, taskRunner.Artifacts(), paths.WorkDir); artifactErr != nil{
+			logger.Logger.WarnLog(userTask, "save artifacts failed, err=", artifactErr)
+			if err == nil{
+				err = artifactErr
+				errClass = common.ErrorClassOutput
+			}
+		}
 
 CREATE_EXEC_RESULT:
 		// 执行结束,解锁
-		taskLock.UnLock()
+		if taskLock != nil{
+			taskLock.UnLock()
+		}
 
-		// CancelCtx超时而退出
+		// CancelCtx超时或者被强杀而退出
 		if taskExecStatus.CancelCtx.Err() == context.DeadlineExceeded{
 			err = common.ERROR_TIMEOUT
+			errClass = common.ErrorClassTimeout
+		}else if taskExecStatus.CancelCtx.Err() == context.Canceled{
+			err = common.ERROR_KILLED
+			errClass = ""
+		}
+		// 锁的租约已经失效，放弃本次执行
+		if taskLock != nil && taskLock.IsLost(){
+			err = common.ERROR_LOCK_LOST
+			errClass = ""
 		}
 
 		// 执行结果信息
 		taskExecResult = &common.TaskExecResult{
 			CurTaskExecStatus: taskExecStatus,
-			CurTaskOutput:     output,
+			CurTaskStdout:     stdout.Bytes(),
+			CurTaskStderr:     stderr.Bytes(),
+			CurTaskExitCode:   exitCode,
+			CurTaskSignal:     signal,
+			CurTaskKillSignal: killSignal,
+			CurTaskArtifacts:  artifacts,
 			CurTaskError:      err,
+			CurTaskResult:     result,
+			CurTaskErrorClass: errClass,
 		}
 
 		// 将执行结果传给调度器(协程通信)
@@ -69,6 +234,114 @@ CREATE_EXEC_RESULT:
 	}()
 }
 
+// 把工作目录中符合patterns(相对于工作目录的通配符)的文件存入存储器
+func saveArtifacts(task *common.Task, patterns []string, workDir string) (artifacts []*common.Artifact, err error) {
+	var (
+		pattern 			string
+		matches 			[]string
+		filePath 			string
+		rel 				string
+		info 				os.FileInfo
+		fp 					*os.File
+		ref 				string
+		saved 				map[string]bool
+	)
+	saved = make(map[string]bool)
+	for _, pattern = range patterns {
+		if matches, err = filepath.Glob(filepath.Join(workDir, pattern)); err != nil {
+			return
+		}
+		for _, filePath = range matches {
+			if rel, err = filepath.Rel(workDir, filePath); err != nil {
+				return
+			}
+			// 符号链接可能指向工作目录以外的文件，不保存
+			if info, err = os.Lstat(filePath); err != nil {
+				return
+			}
+			if !info.Mode().IsRegular() || saved[rel] || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			saved[rel] = true
+			if fp, err = os.Open(filePath); err != nil {
+				return
+			}
+			ref, err = blobStore.Store.Put(path.Join("artifact", strconv.FormatInt(task.TaskId, 10), strconv.Itoa(task.Attempt), filepath.ToSlash(rel)), fp)
+			_ = fp.Close()
+			if err != nil {
+				return
+			}
+			artifacts = append(artifacts, &common.Artifact{
+				Name: filepath.ToSlash(rel),
+				Ref:  ref,
+				Size: info.Size(),
+			})
+		}
+	}
+	return artifacts, nil
+}
+
+// 常见信号的名称
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// 进程的退出码和终止它的信号，进程没有启动时退出码为-1
+func exitStatus(state *os.ProcessState) (exitCode int, signal string) {
+	var (
+		waitStatus 			syscall.WaitStatus
+		ok 					bool
+	)
+	if state == nil {
+		return -1, ""
+	}
+	exitCode = state.ExitCode()
+	if waitStatus, ok = state.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		if signal, ok = signalNames[waitStatus.Signal()]; !ok {
+			signal = waitStatus.Signal().String()
+		}
+	}
+	return
+}
+
+// 把输出目录中的文件存入存储器，返回按文件路径排序的引用
+func saveOutputs(task *common.Task, outputDir string) (refs []string, err error) {
+	err = filepath.Walk(outputDir, func(filePath string, info os.FileInfo, walkErr error) (err error) {
+		var (
+			rel 				string
+			fp 					*os.File
+			ref 				string
+		)
+		if walkErr != nil || info.IsDir() {
+			return walkErr
+		}
+		if rel, err = filepath.Rel(outputDir, filePath); err != nil {
+			return
+		}
+		if fp, err = os.Open(filePath); err != nil {
+			return
+		}
+		ref, err = blobStore.Store.Put(path.Join("output", strconv.FormatInt(task.TaskId, 10), filepath.ToSlash(rel)), fp)
+		_ = fp.Close()
+		if err != nil {
+			return
+		}
+		refs = append(refs, ref)
+		return nil
+	})
+	return
+}
+
 // 任务执行器单例
 var (
 	Exec		*Executor
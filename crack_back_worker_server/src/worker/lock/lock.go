@@ -5,8 +5,12 @@ import (
 	"crack_back/src/common"
 	"crack_back/src/config"
 	"crack_back/src/worker/logger"
+	"crack_back/src/worker/register"
+	"encoding/json"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"path"
+	"sync"
+	"time"
 )
 
 // 锁对应的etcd的API子集
@@ -16,6 +20,13 @@ var (
 	assigned				bool
 )
 
+// 会话租约：会话模式下本worker进程的所有任务锁共用一个长期租约，worker退出或失联时所有锁一起释放
+var (
+	sessionMutex			sync.Mutex
+	sessionLeaseID			clientv3.LeaseID	// 为0时还没有申请或者已经失效
+	sessionLost				chan struct{}		// 会话租约失效时关闭
+)
+
 // 只能设置一次
 func SetEtcdKvAndLeaseAPI(KV clientv3.KV, Lease clientv3.Lease){
 	if !assigned {
@@ -30,9 +41,70 @@ type TaskLock struct {
 
 	doCancelFunc			context.CancelFunc	// 该锁对应的etcd的key的续租取消函数
 	leaseID			 		clientv3.LeaseID	// 该锁对应的etcd的key的租约ID
+	revision 				int64 				// 会话模式下加锁时的版本号，解锁时只删除自己创建的key
+	lost 					chan struct{}		// 锁的租约失效(锁已经被etcd释放)时关闭
 	locked 					bool
 }
 
+// 该锁在etcd中对应的key
+func (This *TaskLock) lockKey() string {
+	return path.Join(config.Cfg.LockDir, This.TaskName)
+}
+
+// 锁的value，记录持有该锁的worker和抢占时间
+func lockValue(leaseID clientv3.LeaseID) string {
+	var (
+		lockInfo 				*common.LockInfo
+		value 					[]byte
+	)
+	lockInfo = &common.LockInfo{
+		Worker:    register.WorkerRegister.WorkerIP(),
+		ClaimTime: time.Now().UnixNano() / 1000 / 1000,
+		LeaseId:   int64(leaseID),
+	}
+	value, _ = json.Marshal(lockInfo)
+	return string(value)
+}
+
+// 获取会话租约及其失效通知，还没有申请或者已经失效时重新申请并自动续租
+func sessionLease() (leaseID clientv3.LeaseID, lost chan struct{}, err error) {
+	var (
+		leaseGrantResp 			*clientv3.LeaseGrantResponse
+		leaseKeepAliveRespChan	<-chan *clientv3.LeaseKeepAliveResponse
+	)
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if sessionLeaseID != 0 {
+		return sessionLeaseID, sessionLost, nil
+	}
+
+	if leaseGrantResp, err = lease.Grant(context.TODO(), config.Cfg.SessionTTL); err != nil{
+		return
+	}
+	leaseID = leaseGrantResp.ID
+	if leaseKeepAliveRespChan, err = lease.KeepAlive(context.TODO(), leaseID); err != nil{
+		_, _ = lease.Revoke(context.TODO(), leaseID)
+		return
+	}
+	sessionLeaseID = leaseID
+	sessionLost = make(chan struct{})
+	lost = sessionLost
+
+	// 续租应答通道关闭说明租约已经失效(其上的锁已经全部释放)，通知持有这些锁的任务，下次加锁时重新申请
+	go func() {
+		for range leaseKeepAliveRespChan {
+		}
+		logger.Logger.WarnLog("会话租约已失效, lease_id=", int64(leaseID))
+		sessionMutex.Lock()
+		if sessionLeaseID == leaseID {
+			sessionLeaseID = 0
+		}
+		sessionMutex.Unlock()
+		close(lost)
+	}()
+	return leaseID, lost, nil
+}
+
 
 
 // 创建一把锁
@@ -48,8 +120,57 @@ func (This*TaskLock) Locked () bool {
 	return This.locked
 }
 
+// 锁的租约失效时关闭的通道。租约失效后锁已经被etcd释放，其他worker可能已经抢到了该任务
+func (This *TaskLock) Lost() <-chan struct{} {
+	return This.lost
+}
+
+// 锁的租约是否已经失效
+func (This *TaskLock) IsLost() bool {
+	select {
+	case <-This.lost:
+		return true
+	default:
+		return false
+	}
+}
+
 // 加锁
 func (This *TaskLock) TryLock () (err error) {
+	if config.Cfg.LockSession {
+		return This.trySessionLock()
+	}
+	return This.tryTaskLock()
+}
+
+// 会话模式加锁：使用会话租约，一次事务完成
+func (This *TaskLock) trySessionLock () (err error) {
+	var (
+		leaseID					clientv3.LeaseID
+		lost					chan struct{}
+		txnResp					*clientv3.TxnResponse
+	)
+	if leaseID, lost, err = sessionLease(); err != nil{
+		return
+	}
+
+	if txnResp, err = kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.CreateRevision(This.lockKey()), "=", 0)).Then(
+		clientv3.OpPut(This.lockKey(), lockValue(leaseID), clientv3.WithLease(leaseID))).Commit(); err != nil{
+		return common.ERROR_TXN_COMMIT
+	}
+	if !txnResp.Succeeded {
+		return common.ERROR_LOCK_REQUIRED
+	}
+	This.leaseID = leaseID
+	This.revision = txnResp.Header.Revision
+	This.lost = lost
+	This.locked = true
+	return nil
+}
+
+// 任务模式加锁：每个任务单独申请租约
+func (This *TaskLock) tryTaskLock () (err error) {
 	var (
 		leaseGrantResp 			*clientv3.LeaseGrantResponse
 		leaseID					clientv3.LeaseID
@@ -61,6 +182,7 @@ func (This *TaskLock) TryLock () (err error) {
 		txnResp					*clientv3.TxnResponse
 
 		lockKey					string
+		lost					chan struct{}
 	)
 
 	// 申请租约
@@ -78,7 +200,8 @@ func (This *TaskLock) TryLock () (err error) {
 		goto FAIL_GET_LOCK
 	}
 
-	// 另开一个协程处理续租应答
+	// 另开一个协程处理续租应答，不是主动取消续租而是续租失败时通知租约失效
+	lost = make(chan struct{})
 	go func() {
 		var (
 			keepAliveResp		*clientv3.LeaseKeepAliveResponse
@@ -93,17 +216,21 @@ func (This *TaskLock) TryLock () (err error) {
 				break
 			}
 		}
+		if cancelCtx.Err() == nil{
+			logger.Logger.WarnLog("任务锁的租约已失效, lease_id=", int64(leaseID))
+			close(lost)
+		}
 	}()
 
 	// 该锁在etcd中对应的key
-	lockKey = path.Join(config.Cfg.LockDir, This.TaskName)
+	lockKey = This.lockKey()
 
 	// 创建事务
 	txn = kv.Txn(context.TODO())
 
 	// 定义事务
 	txn.If(clientv3.Compare(clientv3.CreateRevision(lockKey), "=", 0)).Then(
-		clientv3.OpPut(lockKey, "", clientv3.WithLease(leaseID))).Else(
+		clientv3.OpPut(lockKey, lockValue(leaseID), clientv3.WithLease(leaseID))).Else(
 			clientv3.OpGet(lockKey))
 
 	// 提交事务
@@ -116,6 +243,7 @@ func (This *TaskLock) TryLock () (err error) {
 	if txnResp.Succeeded {
 		This.doCancelFunc = cancelFunc
 		This.leaseID = leaseID
+		This.lost = lost
 		This.locked = true
 	}else{
 		err = common.ERROR_LOCK_REQUIRED
@@ -140,6 +268,15 @@ func (This *TaskLock) UnLock ()  {
 	var(
 		err				error
 	)
+	// 会话模式下只删除自己创建的key，会话租约继续给其他锁使用
+	if This.locked && config.Cfg.LockSession{
+		if _, err = kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.CreateRevision(This.lockKey()), "=", This.revision)).Then(
+			clientv3.OpDelete(This.lockKey())).Commit(); err != nil{
+			logger.Logger.InfoLog("主动释放锁失败, 等待会话租约失效时释放")
+		}
+		return
+	}
 	if This.locked{
 		// 取消续租
 		This.doCancelFunc()
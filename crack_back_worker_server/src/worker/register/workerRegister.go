@@ -4,6 +4,7 @@ import (
 	"context"
 	"crack_back/src/common"
 	"crack_back/src/config"
+	"encoding/json"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 	"net"
@@ -16,6 +17,8 @@ type Register struct {
 	kv 					clientv3.KV
 	lease				clientv3.Lease
 	workerIP			string
+	// 执行容量的更新(由调度器发布，注册协程写入etcd)
+	capacityChan		chan *common.WorkerCapacity
 }
 
 func getIP() (ipv4 string, err error) {
@@ -46,7 +49,28 @@ func getIP() (ipv4 string, err error) {
 	return
 }
 
-// 服务注册(租约+续租)
+// 该worker的IP(同时作为worker的标识)
+func (This *Register) WorkerIP () string {
+	return This.workerIP
+}
+
+// 更新注册信息中的执行容量，只保留最新的一次
+func (This *Register) UpdateCapacity (capacity *common.WorkerCapacity)  {
+	for {
+		select {
+		case This.capacityChan <- capacity:
+			return
+		default:
+			// 丢弃还没有写入的旧容量
+			select {
+			case <-This.capacityChan:
+			default:
+			}
+		}
+	}
+}
+
+// 服务注册(租约+续租)，key的value为worker的执行容量(JSON)
 func (This *Register) keepAlive ()  {
 	var(
 		err 							error
@@ -55,6 +79,8 @@ func (This *Register) keepAlive ()  {
 		leaseID							clientv3.LeaseID
 		leaseKeepAliveChan				<-chan *clientv3.LeaseKeepAliveResponse
 		leaseKeepAliveResp				*clientv3.LeaseKeepAliveResponse
+		capacity						*common.WorkerCapacity
+		capacityValue					[]byte
 
 		op								clientv3.Op
 
@@ -76,12 +102,13 @@ func (This *Register) keepAlive ()  {
 			goto TRY_AGAIN
 		}
 
-		op = clientv3.OpPut(workerKey, "", clientv3.WithLease(leaseID))
+		// 重新注册时带上最近一次的执行容量
+		op = clientv3.OpPut(workerKey, string(capacityValue), clientv3.WithLease(leaseID))
 		if _, err = This.client.Do(cancelCtx, op); err != nil {
 			goto TRY_AGAIN
 		}
 
-		// 处理续租应答
+		// 处理续租应答和执行容量的更新
 		for {
 			select {
 			case leaseKeepAliveResp = <-leaseKeepAliveChan:
@@ -89,6 +116,14 @@ func (This *Register) keepAlive ()  {
 					// 续租失败
 					goto TRY_AGAIN
 				}
+			case capacity = <-This.capacityChan:
+				if capacityValue, err = json.Marshal(capacity); err != nil {
+					break
+				}
+				op = clientv3.OpPut(workerKey, string(capacityValue), clientv3.WithLease(leaseID))
+				if _, err = This.client.Do(cancelCtx, op); err != nil {
+					goto TRY_AGAIN
+				}
 			}
 		}
 
@@ -134,6 +169,7 @@ func InitWorkerRegister() (err error) {
 			kv:       clientv3.NewKV(client),
 			lease:    clientv3.NewLease(client),
 			workerIP: ip,
+			capacityChan: make(chan *common.WorkerCapacity, 1),
 		}
 
 		// 注册服务
@@ -0,0 +1,15 @@
+//go:build go1.20
+// +build go1.20
+
+package runner
+
+import (
+	"os/exec"
+)
+
+// 命令启动时直接进入fd对应的cgroup(clone3的CLONE_INTO_CGROUP，需要Go 1.20以上)
+func useCgroupFD(cmd *exec.Cmd, fd int) (err error) {
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return nil
+}
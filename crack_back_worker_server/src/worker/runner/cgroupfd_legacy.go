@@ -0,0 +1,14 @@
+//go:build !go1.20
+// +build !go1.20
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Go 1.20以下不能让命令启动时直接进入cgroup，改用setrlimit
+func useCgroupFD(cmd *exec.Cmd, fd int) (err error) {
+	return errors.New("进入cgroup需要Go 1.20以上")
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"bufio"
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/logger"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每种任务类型的资源限额(内存、CPU、进程数)。CgroupRoot下可以使用cgroup v2时，每次执行创建一个子cgroup，
+// 模型程序直接在其中启动，超出内存限额被OOM终止、进程数达到上限都能从cgroup的事件计数中识别出来；
+// 否则在模型程序exec之前用setrlimit限制地址空间(CPU和进程数限额只在cgroup下生效)
+
+// 需要在CgroupRoot中开启的控制器
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+// cpu.max的周期(us)
+const cpuPeriod = 100000
+
+// CgroupRoot是否可用(InitRegistry时检查)
+var cgroupAvailable bool
+
+// 检查CgroupRoot是否可用：cgroup v2，可以创建子cgroup，并且memory、cpu、pids控制器都已开启
+func initCgroup() (err error) {
+	var (
+		controllers 		[]byte
+		controller 			string
+	)
+	if config.Cfg.CgroupRoot == "" {
+		return nil
+	}
+	if err = os.MkdirAll(config.Cfg.CgroupRoot, 0755); err != nil {
+		return
+	}
+	// 父cgroup需要把控制器开放给CgroupRoot，CgroupRoot再开放给每次执行的子cgroup(没有权限时只能依赖已有的配置)
+	_ = ioutil.WriteFile(filepath.Join(filepath.Dir(config.Cfg.CgroupRoot), "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0644)
+	if err = ioutil.WriteFile(filepath.Join(config.Cfg.CgroupRoot, "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0644); err != nil {
+		return
+	}
+	if controllers, err = ioutil.ReadFile(filepath.Join(config.Cfg.CgroupRoot, "cgroup.subtree_control")); err != nil {
+		return
+	}
+	for _, controller = range cgroupControllers {
+		if !strings.Contains(" " + strings.TrimSpace(string(controllers)) + " ", " " + controller + " ") {
+			return os.ErrPermission
+		}
+	}
+	cgroupAvailable = true
+	return nil
+}
+
+// 是否配置了资源限额
+func (This *Runner) hasLimits() bool {
+	return This.cfg.MemoryLimit > 0 || This.cfg.CpuLimit > 0 || This.cfg.PidsLimit > 0
+}
+
+// 一次执行的子cgroup
+type cgroup struct {
+	dir 				string
+	fd 					*os.File
+}
+
+// 创建子cgroup并写入限额，命令启动时直接进入该cgroup
+func (This *Runner) newCgroup(name string, cmd *exec.Cmd) (cg *cgroup, err error) {
+	cg = &cgroup{
+		dir: filepath.Join(config.Cfg.CgroupRoot, name),
+	}
+	// 上次执行异常退出时可能留下同名的cgroup
+	_ = os.Remove(cg.dir)
+	if err = os.Mkdir(cg.dir, 0755); err != nil {
+		return nil, err
+	}
+	if This.cfg.MemoryLimit > 0 {
+		if err = cg.write("memory.max", strconv.FormatInt(This.cfg.MemoryLimit, 10)); err != nil {
+			goto FAIL
+		}
+		// 不允许用交换分区绕过内存限额(没有开启swap记账时没有该文件)
+		_ = cg.write("memory.swap.max", "0")
+	}
+	if This.cfg.CpuLimit > 0 {
+		if err = cg.write("cpu.max", strconv.Itoa(int(This.cfg.CpuLimit * cpuPeriod)) + " " + strconv.Itoa(cpuPeriod)); err != nil {
+			goto FAIL
+		}
+	}
+	if This.cfg.PidsLimit > 0 {
+		if err = cg.write("pids.max", strconv.Itoa(This.cfg.PidsLimit)); err != nil {
+			goto FAIL
+		}
+	}
+	if cg.fd, err = os.Open(cg.dir); err != nil {
+		goto FAIL
+	}
+	if err = useCgroupFD(cmd, int(cg.fd.Fd())); err != nil {
+		_ = cg.fd.Close()
+		goto FAIL
+	}
+	return cg, nil
+
+FAIL:
+	_ = os.Remove(cg.dir)
+	return nil, err
+}
+
+// 写cgroup的接口文件
+func (This *cgroup) write(file string, value string) error {
+	return ioutil.WriteFile(filepath.Join(This.dir, file), []byte(value), 0644)
+}
+
+// 读cgroup的事件计数(memory.events、pids.events中的一项)
+func (This *cgroup) event(file string, key string) (count int64) {
+	var (
+		fp 					*os.File
+		scanner 			*bufio.Scanner
+		fields 				[]string
+		err 				error
+	)
+	if fp, err = os.Open(filepath.Join(This.dir, file)); err != nil {
+		return 0
+	}
+	defer fp.Close()
+	scanner = bufio.NewScanner(fp)
+	for scanner.Scan() {
+		if fields = strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == key {
+			count, _ = strconv.ParseInt(fields[1], 10, 64)
+			return
+		}
+	}
+	return 0
+}
+
+// 执行期间是否超出了限额
+func (This *cgroup) breach() (err error, errClass string) {
+	if This.event("memory.events", "oom_kill") > 0 {
+		return common.ERROR_TASK_OOM, common.ErrorClassOOM
+	}
+	if This.event("pids.events", "max") > 0 {
+		return common.ERROR_TASK_PIDS_LIMIT, common.ErrorClassPids
+	}
+	return nil, ""
+}
+
+// 终止cgroup中残留的进程(如脱离了进程组的子进程)并删除cgroup
+func (This *cgroup) remove() {
+	var (
+		retry 				int
+		err 				error
+	)
+	_ = This.fd.Close()
+	// cgroup.kill需要5.14以上的内核
+	_ = This.write("cgroup.kill", "1")
+	for retry = 0; retry < 10; retry++ {
+		if err = os.Remove(This.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	logger.Logger.WarnLog("删除cgroup失败:", This.dir, "err=", err)
+}
+
+// 没有可用的cgroup时，由初始化进程在exec模型程序之前用setrlimit限制地址空间
+// (进程数限额只在cgroup下生效：RLIMIT_NPROC按用户计数，并且对root不生效)
+func (This *Runner) limitInChild(cmd *exec.Cmd) (err error) {
+	var (
+		spec 				*sandboxSpec
+	)
+	if spec, err = initSpec(cmd); err != nil {
+		return
+	}
+	spec.MemoryLimit = This.cfg.MemoryLimit
+	return wrapInit(cmd, spec)
+}
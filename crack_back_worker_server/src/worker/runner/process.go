@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"crack_back/src/worker/logger"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// 模型程序在独立的进程组中执行，强杀或超时时整个进程组(包括模型程序启动的ffmpeg等子进程)一起退出
+
+// worker为强杀或超时发送的信号
+const (
+	KillSignalTerm 			string = "SIGTERM"
+	KillSignalKill 			string = "SIGKILL"
+)
+
+// 以独立的进程组启动命令
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// 关闭传给子进程的额外的文件
+func closeExtraFiles(cmd *exec.Cmd) {
+	var (
+		file 				*os.File
+	)
+	for _, file = range cmd.ExtraFiles {
+		_ = file.Close()
+	}
+}
+
+// 执行命令直到退出。ctx被取消(强杀或超时)时先向整个进程组发送SIGTERM，
+// 等待KillGrace后还没有退出再发送SIGKILL，返回worker最后发送的信号(没有发送时为空)
+// 配置了资源限额时name为本次执行的子cgroup名，超出限额时limitErr为对应的错误
+func (This *Runner) Run(ctx context.Context, cmd *exec.Cmd, name string) (killSignal string, limitErr error, limitClass string, err error) {
+	var (
+		exited 				chan struct{}
+		killed 				chan string
+		cg 					*cgroup
+	)
+	if This.hasLimits() && cgroupAvailable {
+		if cg, err = This.newCgroup(name, cmd); err != nil {
+			logger.Logger.WarnLog("创建cgroup失败，改用setrlimit限制资源:", err)
+		} else {
+			defer cg.remove()
+		}
+	}
+	if cg == nil && This.cfg.MemoryLimit > 0 {
+		if err = This.limitInChild(cmd); err != nil {
+			logger.Logger.WarnLog("设置内存限额失败:", err)
+		}
+	}
+	err = cmd.Start()
+	// 子进程已经继承了额外的文件(如进度管道的写端)，父进程关闭自己的副本，子进程退出后读端才能读到EOF
+	closeExtraFiles(cmd)
+	if err != nil {
+		return
+	}
+
+	exited = make(chan struct{})
+	killed = make(chan string, 1)
+	go func() {
+		var (
+			signal 			string
+			timer 			*time.Timer
+		)
+		defer func() {
+			killed <- signal
+		}()
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		// 进程组id等于模型程序的pid，向负的pid发送信号即发送给整个进程组
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		signal = KillSignalTerm
+		timer = time.NewTimer(This.cfg.KillGrace)
+		defer timer.Stop()
+		select {
+		case <-exited:
+		case <-timer.C:
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			signal = KillSignalKill
+		}
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+	killSignal = <-killed
+	if cg != nil {
+		limitErr, limitClass = cg.breach()
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/logger"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 模型程序注册表：任务类型 --> 模型程序
+// 执行任务时根据任务类型找到模型程序，再由任务信息和待识别文件路径生成命令
+
+type Runner struct {
+	cfg 				*config.RunnerConfig
+}
+
+// 一次执行用到的本地路径
+type RunPaths struct {
+	Input 				string				// 待识别文件
+	Inputs 				[]string			// 上游步骤的输出文件
+	OutputDir 			string				// 输出目录
+	WorkDir 			string				// 本次执行的工作目录(产物文件写在这里)
+}
+
+// 是否从标准输出中解析裂缝识别结果
+func (This *Runner) ParseResult() bool {
+	return This.cfg.ParseResult
+}
+
+// 该任务类型同时执行的任务数上限，0表示只受worker的上限约束
+func (This *Runner) MaxConcurrency() int {
+	return This.cfg.MaxConcurrency
+}
+
+// 需要保存的产物文件(相对于工作目录的通配符)
+func (This *Runner) Artifacts() []string {
+	return This.cfg.Artifacts
+}
+
+// 任务未指定超时时间时使用的默认超时时间
+func (This *Runner) Timeout() time.Duration {
+	return This.cfg.Timeout
+}
+
+// 任务以errClass类错误失败后是否还能重试，能重试时返回重试前等待的时间
+func (This *Runner) RetryDelay(task *common.Task, errClass string) (delay time.Duration, ok bool) {
+	var (
+		maxRetries 			int
+		class 				string
+		retryable 			bool
+		i 					int
+	)
+	maxRetries = task.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = This.cfg.MaxRetries
+	}
+	if task.Attempt >= maxRetries {
+		return 0, false
+	}
+	for _, class = range This.cfg.RetryOn {
+		if class == errClass {
+			retryable = true
+			break
+		}
+	}
+	if !retryable {
+		return 0, false
+	}
+
+	// 指数退避
+	delay = time.Duration(task.RetryBackoff) * time.Second
+	if delay <= 0 {
+		delay = This.cfg.RetryBackoff
+	}
+	// 每次重试翻倍，没有上限时只避免溢出
+	for i = 0; i < task.Attempt && delay < math.MaxInt64 / 2; i++ {
+		delay *= 2
+	}
+	if This.cfg.RetryMaxBackoff > 0 && delay > This.cfg.RetryMaxBackoff {
+		delay = This.cfg.RetryMaxBackoff
+	}
+	return delay, true
+}
+
+// 根据任务信息生成命令
+func (This *Runner) BuildCommand(task *common.Task, paths *RunPaths) (cmd *exec.Cmd, err error) {
+	var (
+		replacer 			*strings.Replacer
+		args 				[]string
+		arg 				string
+		name 				string
+	)
+	// 先按空白切分参数模板再替换占位符，占位符的值中包含空格也不会被拆成多个参数
+	replacer = strings.NewReplacer(
+		"{script}", This.cfg.Script,
+		"{input}", paths.Input,
+		"{inputs}", strings.Join(paths.Inputs, ","),
+		"{output}", paths.OutputDir,
+		"{workdir}", paths.WorkDir,
+		"{task_id}", strconv.FormatInt(task.TaskId, 10),
+		"{task_name}", task.TaskName,
+		"{task_type}", task.TaskType,
+		"{user_id}", strconv.FormatInt(task.UserId, 10),
+		"{start}", strconv.FormatFloat(task.SegmentStart, 'f', -1, 64),
+		"{end}", strconv.FormatFloat(task.SegmentEnd, 'f', -1, 64),
+	)
+	args = make([]string, 0, len(This.cfg.Args))
+	for _, arg = range This.cfg.Args {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	// 没有解释器时直接执行模型程序
+	name = This.cfg.Interpreter
+	if name == "" {
+		name = This.cfg.Script
+	}
+
+	cmd = exec.Command(name, args...)
+	setProcessGroup(cmd)
+	// 没有配置工作目录时在本次执行的工作目录中执行
+	cmd.Dir = This.cfg.WorkDir
+	if cmd.Dir == "" {
+		cmd.Dir = paths.WorkDir
+	}
+	cmd.Env = append(os.Environ(), This.cfg.Env...)
+	cmd.Env = append(cmd.Env,
+		"CRACK_TASK_ID=" + strconv.FormatInt(task.TaskId, 10),
+		"CRACK_TASK_TYPE=" + task.TaskType,
+		"CRACK_INPUT=" + paths.Input,
+		"CRACK_INPUTS=" + strings.Join(paths.Inputs, ","),
+		"CRACK_OUTPUT_DIR=" + paths.OutputDir,
+		"CRACK_WORK_DIR=" + paths.WorkDir,
+		"CRACK_SEGMENT_START=" + strconv.FormatFloat(task.SegmentStart, 'f', -1, 64),
+		"CRACK_SEGMENT_END=" + strconv.FormatFloat(task.SegmentEnd, 'f', -1, 64),
+	)
+
+	// 配置了沙箱的任务类型在沙箱中执行
+	if This.cfg.Sandbox {
+		if err = This.sandbox(cmd, paths); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+type Registry struct {
+	runners 			map[string]*Runner
+}
+
+// 查找任务类型对应的模型程序
+func (This *Registry) Lookup(taskType string) (runner *Runner, err error) {
+	var (
+		ok 					bool
+	)
+	if runner, ok = This.runners[taskType]; !ok {
+		return nil, common.ERROR_RUNNER_NOT_FOUND
+	}
+	return runner, nil
+}
+
+// 所有配置了模型程序的任务类型
+func (This *Registry) TaskTypes() (taskTypes []string) {
+	var (
+		taskType 			string
+	)
+	for taskType = range This.runners {
+		taskTypes = append(taskTypes, taskType)
+	}
+	return
+}
+
+// 模型程序注册表单例
+var (
+	Runners				*Registry
+)
+
+func InitRegistry() (err error) {
+	if Runners == nil {
+		var (
+			registry 			*Registry
+			runnerConfig 		*config.RunnerConfig
+		)
+		registry = &Registry{
+			runners: make(map[string]*Runner, len(config.Cfg.Runners)),
+		}
+		for _, runnerConfig = range config.Cfg.Runners {
+			// 模型程序必须存在
+			if _, err = os.Stat(runnerConfig.Script); err != nil {
+				return err
+			}
+			// 沙箱必须切换到非特权用户
+			if runnerConfig.Sandbox && (runnerConfig.SandboxUid == 0 || runnerConfig.SandboxGid == 0) {
+				return common.ERROR_SANDBOX_USER
+			}
+			registry.runners[runnerConfig.TaskType] = &Runner{
+				cfg: runnerConfig,
+			}
+		}
+
+		// 资源限额优先使用cgroup v2
+		if err = initCgroup(); err != nil {
+			logger.Logger.WarnLog("cgroup不可用，改用setrlimit限制资源:", err)
+			err = nil
+		}
+
+		// 赋值单例
+		Runners = registry
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 沙箱：模型程序在独立的mount、network、IPC、UTS命名空间中以专用的非特权UID执行
+// 整个文件系统只读，只有本次执行的输出目录和工作目录可写(输入文件是多个任务共享的缓存，只读)，/tmp为私有的tmpfs，没有网络
+// 输入文件、输出目录和工作目录在/tmp下时绑定到私有/tmp中的同一路径
+// 挂载需要在新的命名空间中、切换用户之前完成，因此先以SandboxInitArg参数重新执行worker本身，
+// 由它完成挂载并切换用户后再exec模型程序。worker需要以root(或CAP_SYS_ADMIN、CAP_SETUID、CAP_SETGID)运行
+// 没有可用的cgroup时，内存限额也由该初始化进程在exec模型程序之前用setrlimit设置(不在沙箱中执行时同样重新执行worker)
+
+// 重新执行worker时的第一个参数
+const SandboxInitArg = "__crack_sandbox_init"
+
+// 沙箱配置在环境变量中传给SandboxInit，exec模型程序前删除
+const sandboxSpecEnv = "CRACK_SANDBOX_SPEC"
+
+// 沙箱的挂载和用户配置
+type sandboxSpec struct {
+	Isolate 			bool 			`json:"isolate"`			// 是否隔离(为false时只设置资源限额)
+	MemoryLimit 		int64 			`json:"memory_limit"`		// 地址空间限额(字节，0表示不限制)
+	Uid 				int 			`json:"uid"`
+	Gid 				int 			`json:"gid"`
+	ReadOnly 			[]string 		`json:"read_only"`			// 只读的输入文件
+	Writable 			[]string 		`json:"writable"`			// 可写的目录
+	TmpSize 			int 			`json:"tmp_size"`			// /tmp的大小(MB)
+	Path 				string 			`json:"path"`				// 模型程序(或解释器)的路径
+	Args 				[]string 		`json:"args"`
+	Dir 				string 			`json:"dir"`				// 工作目录
+}
+
+// 把命令改为在沙箱中执行
+func (This *Runner) sandbox(cmd *exec.Cmd, paths *RunPaths) (err error) {
+	var (
+		spec 				*sandboxSpec
+		dir 				string
+	)
+	spec = &sandboxSpec{
+		Isolate:  true,
+		Uid:      This.cfg.SandboxUid,
+		Gid:      This.cfg.SandboxGid,
+		ReadOnly: append([]string{paths.Input}, paths.Inputs...),
+		Writable: []string{paths.OutputDir, paths.WorkDir},
+		TmpSize:  This.cfg.SandboxTmpSize,
+		Path:     cmd.Path,
+		Args:     cmd.Args,
+		Dir:      cmd.Dir,
+	}
+	// 输出目录和工作目录由worker创建，需要交给沙箱用户
+	for _, dir = range spec.Writable {
+		if err = os.Chown(dir, spec.Uid, spec.Gid); err != nil {
+			return
+		}
+	}
+	if err = wrapInit(cmd, spec); err != nil {
+		return
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	return nil
+}
+
+// 把命令改为先执行初始化进程，由它按spec完成准备后再exec原来的命令。命令已经改过时只更新spec
+func wrapInit(cmd *exec.Cmd, spec *sandboxSpec) (err error) {
+	var (
+		specValue 			[]byte
+		self 				string
+		index 				int
+	)
+	if specValue, err = json.Marshal(spec); err != nil {
+		return
+	}
+	for index = range cmd.Env {
+		if strings.HasPrefix(cmd.Env[index], sandboxSpecEnv + "=") {
+			cmd.Env[index] = sandboxSpecEnv + "=" + string(specValue)
+			return nil
+		}
+	}
+	if self, err = os.Executable(); err != nil {
+		return
+	}
+
+	cmd.Path = self
+	cmd.Args = []string{self, SandboxInitArg}
+	cmd.Dir = ""
+	cmd.Env = append(cmd.Env, sandboxSpecEnv + "=" + string(specValue))
+	return nil
+}
+
+// 命令已经改为执行初始化进程时取出它的spec，否则返回原来的命令对应的spec
+func initSpec(cmd *exec.Cmd) (spec *sandboxSpec, err error) {
+	var (
+		kv 					string
+	)
+	for _, kv = range cmd.Env {
+		if strings.HasPrefix(kv, sandboxSpecEnv + "=") {
+			spec = &sandboxSpec{}
+			err = json.Unmarshal([]byte(strings.TrimPrefix(kv, sandboxSpecEnv + "=")), spec)
+			return
+		}
+	}
+	return &sandboxSpec{
+		Path: cmd.Path,
+		Args: cmd.Args,
+		Dir:  cmd.Dir,
+	}, nil
+}
+
+// 是否是重新执行的沙箱初始化进程
+func IsSandboxInit() bool {
+	return len(os.Args) > 1 && os.Args[1] == SandboxInitArg
+}
+
+// 初始化进程：在沙箱中执行时完成挂载、切换用户，设置资源限额后exec模型程序，失败时以126退出
+func SandboxInit() {
+	var (
+		spec 				*sandboxSpec
+		env 				[]string
+		kv 					string
+		err 				error
+	)
+	spec = &sandboxSpec{}
+	for _, kv = range os.Environ() {
+		if strings.HasPrefix(kv, sandboxSpecEnv + "=") {
+			err = json.Unmarshal([]byte(strings.TrimPrefix(kv, sandboxSpecEnv + "=")), spec)
+			continue
+		}
+		env = append(env, kv)
+	}
+	if err == nil && spec.Path == "" {
+		err = fmt.Errorf("缺少%s", sandboxSpecEnv)
+	}
+	if err == nil && spec.Isolate {
+		err = setupSandbox(spec)
+	}
+	if err == nil && spec.Dir != "" {
+		err = os.Chdir(spec.Dir)
+	}
+	// 地址空间限额最后设置，避免限制初始化进程本身
+	if err == nil && spec.MemoryLimit > 0 {
+		err = syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: uint64(spec.MemoryLimit), Max: uint64(spec.MemoryLimit)})
+	}
+	if err == nil {
+		err = syscall.Exec(spec.Path, spec.Args, env)
+	}
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(126)
+}
+
+// 在新的mount命名空间中挂载并切换用户
+func setupSandbox(spec *sandboxSpec) (err error) {
+	var (
+		mountPoints 		[]string
+		mountPoint 			string
+		statfs 				syscall.Statfs_t
+		file 				string
+		files 				[]*os.File
+		fp 					*os.File
+		index 				int
+	)
+	// 挂载不传播到worker所在的命名空间
+	if err = syscall.Mount("", "/", "", syscall.MS_REC | syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("mount private: %v", err)
+	}
+
+	// 除了/proc、/sys、/dev以外的挂载点全部只读(保留原有的nodev、noexec等标志)
+	if mountPoints, err = readMountPoints(); err != nil {
+		return
+	}
+	for _, mountPoint = range mountPoints {
+		if isPseudoMount(mountPoint) {
+			continue
+		}
+		// 被其他挂载覆盖、已经看不到的挂载点
+		if syscall.Statfs(mountPoint, &statfs) != nil {
+			continue
+		}
+		if err = syscall.Mount("", mountPoint, "", syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_NOSUID |
+			uintptr(statfs.Flags) & (syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME), ""); err != nil {
+			return fmt.Errorf("remount %s read-only: %v", mountPoint, err)
+		}
+	}
+
+	// 输入文件和可写的目录可能在/tmp下(如CacheDir)，挂载tmpfs之前先打开，之后再从/proc/self/fd绑定回原来的路径
+	for _, file = range append(append([]string{}, spec.ReadOnly...), spec.Writable...) {
+		if fp, err = os.Open(filepath.Clean(file)); err != nil {
+			return
+		}
+		files = append(files, fp)
+	}
+
+	// 私有的/tmp
+	if err = syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV,
+		"mode=1777,size=" + strconv.Itoa(spec.TmpSize) + "m"); err != nil {
+		return fmt.Errorf("mount /tmp: %v", err)
+	}
+
+	for index, fp = range files {
+		if err = bindFile(fp, index >= len(spec.ReadOnly)); err != nil {
+			return
+		}
+		_ = fp.Close()
+	}
+
+	// 切换到非特权用户(Go的Setuid等作用于所有线程)
+	if err = syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err = syscall.Setgid(spec.Gid); err != nil {
+		return fmt.Errorf("setgid: %v", err)
+	}
+	if err = syscall.Setuid(spec.Uid); err != nil {
+		return fmt.Errorf("setuid: %v", err)
+	}
+	return nil
+}
+
+// 把已经打开的文件(或目录)绑定回原来的路径，挂载点在tmpfs中被覆盖时重新创建
+func bindFile(fp *os.File, writable bool) (err error) {
+	var (
+		info 				os.FileInfo
+		target 				*os.File
+		flags 				uintptr
+	)
+	if info, err = fp.Stat(); err != nil {
+		return
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(fp.Name(), 0755)
+	} else if err = os.MkdirAll(filepath.Dir(fp.Name()), 0755); err == nil {
+		if target, err = os.OpenFile(fp.Name(), os.O_RDONLY | os.O_CREATE, 0644); err == nil {
+			_ = target.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("create mount point %s: %v", fp.Name(), err)
+	}
+
+	if err = syscall.Mount("/proc/self/fd/" + strconv.Itoa(int(fp.Fd())), fp.Name(), "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s: %v", fp.Name(), err)
+	}
+	flags = syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_NOSUID | syscall.MS_NODEV
+	if !writable {
+		flags |= syscall.MS_RDONLY
+	}
+	if err = syscall.Mount("", fp.Name(), "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s: %v", fp.Name(), err)
+	}
+	return nil
+}
+
+// 读取当前mount命名空间中的挂载点(按挂载顺序，父挂载点在前)
+func readMountPoints() (mountPoints []string, err error) {
+	var (
+		fp 					*os.File
+		scanner 			*bufio.Scanner
+		fields 				[]string
+		seen 				map[string]bool
+	)
+	if fp, err = os.Open("/proc/self/mountinfo"); err != nil {
+		return
+	}
+	defer fp.Close()
+	seen = make(map[string]bool)
+	scanner = bufio.NewScanner(fp)
+	for scanner.Scan() {
+		// 第5列为挂载点，其中的空格等字符被转义为\040
+		if fields = strings.Fields(scanner.Text()); len(fields) < 5 {
+			continue
+		}
+		if !seen[fields[4]] {
+			seen[fields[4]] = true
+			mountPoints = append(mountPoints, unescapeMountPoint(fields[4]))
+		}
+	}
+	return mountPoints, scanner.Err()
+}
+
+// mountinfo中的八进制转义
+func unescapeMountPoint(mountPoint string) string {
+	var (
+		builder 			strings.Builder
+		i 					int
+		value 				uint64
+		err 				error
+	)
+	for i = 0; i < len(mountPoint); i++ {
+		// 转义为反斜杠加3位八进制数，需要完整的4个字节(可以在结尾)
+		if mountPoint[i] == '\\' && i + 4 <= len(mountPoint) {
+			if value, err = strconv.ParseUint(mountPoint[i+1:i+4], 8, 8); err == nil {
+				builder.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		builder.WriteByte(mountPoint[i])
+	}
+	return builder.String()
+}
+
+// 不需要改为只读的伪文件系统
+func isPseudoMount(mountPoint string) bool {
+	var (
+		prefix 				string
+	)
+	for _, prefix = range []string{"/proc", "/sys", "/dev"} {
+		if mountPoint == prefix || strings.HasPrefix(mountPoint, prefix + "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"bytes"
 	"context"
 	"crack_back/src/common"
 	"crack_back/src/config"
@@ -8,11 +9,17 @@ import (
 	"crack_back/src/worker/executor"
 	"crack_back/src/worker/logger"
 	"crack_back/src/worker/notifier"
+	"crack_back/src/worker/register"
+	"crack_back/src/worker/runner"
+	"crack_back/src/worker/statusManager"
 	"crack_back/src/worker/taskLogger"
 	"encoding/json"
 	"errors"
 	"path"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,10 +27,14 @@ import (
 type Scheduler struct {
 	// 任务事件队列
 	EventChan 			chan *common.TaskEvent
-	// 任务执行状态表 user_id/task_name --> status
+	// 任务执行状态表 类型/用户id/任务名 --> status
 	ExecStatus			map[string]*common.TaskExecStatus
 	// 任务执行结果队列
 	ExecResultChan		chan *common.TaskExecResult
+	// 等待执行的任务
+	Queue				*TaskQueue
+	// 最近一次发布的执行容量
+	lastCapacity		[]byte
 }
 
 // 调度器的事件循环:监听调度器管道
@@ -32,8 +43,12 @@ func (This *Scheduler) loop ()  {
 		err 				error
 		taskEvent			*common.TaskEvent
 		taskExecResult		*common.TaskExecResult
+		dispatchTicker		*time.Ticker
 	)
 
+	// 等待重试或休眠的任务到时间后没有新的事件，需要定时检查队首和过期的任务
+	dispatchTicker = time.NewTicker(time.Second)
+
 	// 处理到来的调度事件
 	for{
 		select {
@@ -47,7 +62,166 @@ func (This *Scheduler) loop ()  {
 				logger.Logger.InfoLog(err)
 			}
 			break
+		case <-dispatchTicker.C:
+			break
 		}
+
+		// 有空闲的执行槽位时从队首取任务执行，并发布剩余的执行容量
+		This.dispatch()
+		This.publishCapacity()
+	}
+}
+
+// 本worker的标签：配置的标签 + 配置了模型程序的任务类型
+func (This *Scheduler) labels() (labels map[string]string) {
+	var (
+		key 				string
+		value 				string
+		taskTypes 			[]string
+	)
+	labels = make(map[string]string, len(config.Cfg.Labels) + 1)
+	for key, value = range config.Cfg.Labels {
+		labels[key] = value
+	}
+	taskTypes = runner.Runners.TaskTypes()
+	sort.Strings(taskTypes)
+	labels[common.LabelTaskType] = strings.Join(taskTypes, "|")
+	return
+}
+
+// 本worker能否执行该任务：配置了该任务类型的模型程序，并且标签匹配任务的标签选择器
+func (This *Scheduler) canServe(task *common.Task) bool {
+	var (
+		err 				error
+	)
+	if _, err = runner.Runners.Lookup(task.TaskType); err != nil {
+		return false
+	}
+	return common.MatchSelector(task.Selector, This.labels())
+}
+
+// 同时执行的任务数上限
+func (This *Scheduler) maxRunning() int {
+	if config.Cfg.MaxConcurrency > 0 {
+		return config.Cfg.MaxConcurrency
+	}
+	return runtime.NumCPU()
+}
+
+// 每个任务类型在本worker上正在执行的任务数
+func (This *Scheduler) runningByType() (running map[string]int) {
+	var (
+		taskExecStatus 		*common.TaskExecStatus
+	)
+	running = make(map[string]int)
+	for _, taskExecStatus = range This.ExecStatus {
+		running[taskExecStatus.CurTask.TaskType]++
+	}
+	return
+}
+
+// 任务类型的并发上限，0表示只受worker的上限约束
+func (This *Scheduler) typeLimit(taskType string) int {
+	var (
+		taskRunner 			*runner.Runner
+		err 				error
+	)
+	if taskRunner, err = runner.Runners.Lookup(taskType); err != nil {
+		return 0
+	}
+	return taskRunner.MaxConcurrency()
+}
+
+// 已经达到并发上限的任务类型
+func (This *Scheduler) fullTypes(running map[string]int) (full map[string]bool) {
+	var (
+		taskType 			string
+		count 				int
+		limit 				int
+	)
+	full = make(map[string]bool)
+	for taskType, count = range running {
+		if limit = This.typeLimit(taskType); limit > 0 && count >= limit {
+			full[taskType] = true
+		}
+	}
+	return
+}
+
+// 执行容量有变化时更新注册信息，master和其他组件据此了解worker的空闲槽位
+func (This *Scheduler) publishCapacity() {
+	var (
+		capacity 			*common.WorkerCapacity
+		running 			map[string]int
+		taskType 			string
+		typeCapacity 		*common.TypeCapacity
+		limit 				int
+		capacityValue 		[]byte
+		err 				error
+	)
+	// worker还没有初始化完成
+	if register.WorkerRegister == nil || runner.Runners == nil {
+		return
+	}
+
+	running = This.runningByType()
+	capacity = &common.WorkerCapacity{
+		Ip:         register.WorkerRegister.WorkerIP(),
+		MaxRunning: This.maxRunning(),
+		Running:    len(This.ExecStatus),
+		Queued:     This.Queue.Len(),
+		Labels:     This.labels(),
+		Types:      make(map[string]*common.TypeCapacity),
+	}
+	if capacity.Free = capacity.MaxRunning - capacity.Running; capacity.Free < 0 {
+		capacity.Free = 0
+	}
+	for _, taskType = range runner.Runners.TaskTypes() {
+		typeCapacity = &common.TypeCapacity{
+			MaxRunning: capacity.MaxRunning,
+			Running:    running[taskType],
+		}
+		if limit = This.typeLimit(taskType); limit > 0 && limit < typeCapacity.MaxRunning {
+			typeCapacity.MaxRunning = limit
+		}
+		if typeCapacity.Free = typeCapacity.MaxRunning - typeCapacity.Running; typeCapacity.Free > capacity.Free {
+			typeCapacity.Free = capacity.Free
+		} else if typeCapacity.Free < 0 {
+			typeCapacity.Free = 0
+		}
+		capacity.Types[taskType] = typeCapacity
+	}
+
+	// 只在容量变化时发布
+	if capacityValue, err = json.Marshal(capacity); err != nil || bytes.Equal(capacityValue, This.lastCapacity) {
+		return
+	}
+	This.lastCapacity = capacityValue
+	capacity.UpdateTime = time.Now().UnixNano() / 1000 / 1000
+	register.WorkerRegister.UpdateCapacity(capacity)
+}
+
+// 从等待队列的队首取任务执行，直到执行槽位用完
+func (This *Scheduler) dispatch() {
+	var (
+		running 			map[int64]int
+		taskExecStatus 		*common.TaskExecStatus
+		task 				*common.Task
+	)
+	for task = This.Queue.PopExpired(); task != nil; task = This.Queue.PopExpired() {
+		_ = This.ExecTask(task)
+	}
+	// 超出并发上限的任务留在队列中不抢锁，其他有空闲槽位的worker会抢到它
+	for This.Queue.Len() > 0 && len(This.ExecStatus) < This.maxRunning() {
+		// 每个用户在本worker上正在执行的任务数
+		running = make(map[int64]int)
+		for _, taskExecStatus = range This.ExecStatus {
+			running[taskExecStatus.CurTask.UserId]++
+		}
+		if task = This.Queue.Pop(running, This.fullTypes(This.runningByType())); task == nil {
+			return
+		}
+		_ = This.ExecTask(task)
 	}
 }
 
@@ -60,16 +234,31 @@ func (This *Scheduler) solveTaskEvent(taskEvent *common.TaskEvent) (err error)
 
 	switch taskEvent.CurEvent {
 	case common.EventSave:
-		// 任务到达，准备抢锁执行
-		This.ExecTask(taskEvent.CurTask)
+		// 本worker不能执行的任务不入队，也就不会抢它的锁，留给其他worker
+		// 分配模式下同时退回分配记录，由Leader分配给其他worker
+		if !This.canServe(taskEvent.CurTask) {
+			This.Queue.Remove(path.Join(taskEvent.CurTask.TaskType, strconv.Itoa(int(taskEvent.CurTask.UserId)), taskEvent.CurTask.TaskName))
+			if err = statusManager.SM.ReleaseAssignment(taskEvent.CurTask); err != nil {
+				return err
+			}
+			break
+		}
+		// 任务到达，进入等待队列，轮到它时再抢锁执行
+		This.Queue.Push(path.Join(taskEvent.CurTask.TaskType, strconv.Itoa(int(taskEvent.CurTask.UserId)), taskEvent.CurTask.TaskName), taskEvent.CurTask)
 		break
 	case common.EventDelete:
-		// 删除任务事件
-
+		// 删除任务事件：还在等待的任务不再执行
+		This.Queue.Remove(taskEvent.CurTask.TaskName)
 		break
 	case common.EventKill:
 		// 强杀该任务(取消Command执行, CancelFunc())
 		if taskExecStatus, ok = This.ExecStatus[taskEvent.CurTask.TaskName]; !ok{
+			This.Queue.Remove(taskEvent.CurTask.TaskName)
+			// 还没有被任何worker抢占的任务直接置为killed，之后抢到锁的worker不会再执行它
+			// 已经被其他worker抢占的任务由该worker强杀，这里不处理
+			if err = statusManager.SM.KillPending(taskEvent.CurTask); err == nil || err == common.ERROR_STATE_TRANSITION{
+				return nil
+			}
 			return common.ERROR_KILLTASK
 		}else{
 			taskExecStatus.DoCancelFunc()
@@ -95,7 +284,9 @@ func (This *Scheduler) solveTaskExecResult(taskExecResult *common.TaskExecResult
 	)
 	task = taskExecResult.CurTaskExecStatus.CurTask
 	userTask = path.Join(path.Join(task.TaskType, strconv.Itoa(int(task.UserId)), task.TaskName))
-	logger.Logger.InfoLog(userTask, "output=", string(taskExecResult.CurTaskOutput), "err=", taskExecResult.CurTaskError)
+	logger.Logger.InfoLog(userTask, "stdout=", string(taskExecResult.CurTaskStdout), "stderr=", string(taskExecResult.CurTaskStderr),
+		"exit_code=", taskExecResult.CurTaskExitCode, "signal=", taskExecResult.CurTaskSignal,
+		"kill_signal=", taskExecResult.CurTaskKillSignal, "err=", taskExecResult.CurTaskError)
 
 	if _, ok = This.ExecStatus[userTask]; !ok{
 		return common.ERROR_DELETE_EXECSTATUS
@@ -103,9 +294,33 @@ func (This *Scheduler) solveTaskExecResult(taskExecResult *common.TaskExecResult
 	delete(This.ExecStatus, userTask)
 
 	// 写日志[加锁失败很正常，这类日志可忽略，否则在worker很多的情况下将导致大量加锁失败的日志]
-	if taskExecResult.CurTaskError != common.ERROR_LOCK_REQUIRED {
+	// 没有对应模型程序的任务由其他worker执行，已经结束或被强杀的任务不再执行，同样忽略
+	if taskExecResult.CurTaskError != common.ERROR_LOCK_REQUIRED && taskExecResult.CurTaskError != common.ERROR_RUNNER_NOT_FOUND &&
+		taskExecResult.CurTaskError != common.ERROR_STATE_TRANSITION {
 		taskLogger.Logger.PushTaskLog(This.NewTaskLog(taskExecResult))
 
+		// 锁的租约失效时本次执行已被放弃，任务重新入队由抢到锁的worker执行，不通知失败
+		if taskExecResult.CurTaskError == common.ERROR_LOCK_LOST {
+			if err = statusManager.SM.Abandon(task, taskExecResult.CurTaskError); err != nil {
+				logger.Logger.WarnLog(userTask, "abandon failed, err=", err.Error())
+			}
+			return nil
+		}
+
+		// 可以重试的失败重新入队，只有最后一次也失败时才通知失败并报警
+		if taskExecResult.CurTaskError != nil && This.retryTask(taskExecResult) {
+			return nil
+		}
+
+		// 更新任务状态
+		if err = statusManager.SM.Transition(task, This.FinalTaskState(taskExecResult.CurTaskError), taskExecResult.CurTaskError); err != nil {
+			logger.Logger.WarnLog(userTask, "update status failed, err=", err.Error())
+		}
+		// 任务已经结束，退回分配记录
+		if err = statusManager.SM.ReleaseAssignment(task); err != nil {
+			logger.Logger.WarnLog(userTask, "release assignment failed, err=", err.Error())
+		}
+
 		// 某类错误将触发报警[这里是除了加锁失败的所有错误都将报警]
 		if taskExecResult.CurTaskError != nil{
 			// 通知任务失败,往fail目录下插入key
@@ -123,6 +338,11 @@ func (This *Scheduler) solveTaskExecResult(taskExecResult *common.TaskExecResult
 
 
 		} else {
+			// 保存识别结果(没有识别结果的任务类型不保存)
+			if taskExecResult.CurTaskResult != nil {
+				taskLogger.Logger.PushTaskResult(This.NewTaskResult(taskExecResult))
+			}
+
 			// 通知任务成功,往finish目录下插入key
 			if err = notifier.Notify.NotifyTaskFinished(task); err != nil {
 				logger.Logger.WarnLog(userTask, "notify Fail failed, err=", err.Error())
@@ -134,6 +354,39 @@ func (This *Scheduler) solveTaskExecResult(taskExecResult *common.TaskExecResult
 }
 
 
+// 失败的任务还能重试时重新入队，返回是否已重新入队
+func (This *Scheduler) retryTask(taskExecResult *common.TaskExecResult) bool {
+	var (
+		task				*common.Task
+		next				common.Task
+		taskRunner			*runner.Runner
+		delay				time.Duration
+		ok					bool
+		err					error
+	)
+	task = taskExecResult.CurTaskExecStatus.CurTask
+	if taskRunner, err = runner.Runners.Lookup(task.TaskType); err != nil{
+		return false
+	}
+	if delay, ok = taskRunner.RetryDelay(task, taskExecResult.CurTaskErrorClass); !ok{
+		return false
+	}
+
+	next = *task
+	next.Attempt = task.Attempt + 1
+	next.RetryAt = time.Now().Add(delay).UnixNano() / 1000 / 1000
+	// 重试时已经过了最晚开始时间，不再重试
+	if next.Expired(next.RetryAt) {
+		return false
+	}
+	if err = statusManager.SM.Retry(task, taskExecResult.CurTaskError, &next); err != nil{
+		logger.Logger.WarnLog(task.TaskName, "retry failed, err=", err.Error())
+		return false
+	}
+	logger.Logger.InfoLog(task.TaskName, "第", next.Attempt, "次重试, 等待", delay)
+	return true
+}
+
 // 执行任务
 func (This *Scheduler) ExecTask (task *common.Task) (err error) {
 	var(
@@ -182,22 +435,38 @@ func (This *Scheduler) NewTaskExecStatus (task *common.Task) (taskExecStatus *co
 	var(
 		ctx 			context.Context
 		cancelFunc 		context.CancelFunc
+		timeout			time.Duration
+		taskRunner		*runner.Runner
+		err 			error
 	)
 
+	// 任务没有指定超时时间时使用模型程序的默认超时时间
+	timeout = time.Duration(task.TaskTimeOut) * time.Second
+	if timeout <= 0 {
+		if taskRunner, err = runner.Runners.Lookup(task.TaskType); err == nil{
+			timeout = taskRunner.Timeout()
+		}
+	}
+
 	// 超时上下文
-	if task.TaskTimeOut > 0 {
-		ctx, cancelFunc = context.WithTimeout(context.TODO(), time.Duration(task.TaskTimeOut) * time.Second)
+	if timeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(context.TODO(), timeout)
 	}else {
 		ctx, cancelFunc = context.WithCancel(context.TODO())
 	}
 
 	taskExecStatus = &common.TaskExecStatus{
 		CurTask:          task,
+		RealScheduleTime: time.Now(),
 		ExecTime:         time.Time{},
 		FinishTime:       time.Time{},
 		CancelCtx:        ctx,
 		DoCancelFunc:     cancelFunc,
 	}
+	// 定时生成的任务有计划执行的时间
+	if task.ScheduleTime > 0 {
+		taskExecStatus.ScheduleTime = time.Unix(0, task.ScheduleTime * int64(time.Millisecond))
+	}
 	return
 }
 
@@ -209,7 +478,16 @@ func (This *Scheduler) NewTaskLog(taskExecResult *common.TaskExecResult) (taskLo
 		TaskId: 		  taskExecResult.CurTaskExecStatus.CurTask.TaskId,
 		TaskType: 		  taskExecResult.CurTaskExecStatus.CurTask.TaskType,
 		UserId:			  taskExecResult.CurTaskExecStatus.CurTask.UserId,
-		TaskOutput:       string(taskExecResult.CurTaskOutput),
+		Attempt:		  taskExecResult.CurTaskExecStatus.CurTask.Attempt,
+		TaskStdout:       string(taskExecResult.CurTaskStdout),
+		TaskStderr:       string(taskExecResult.CurTaskStderr),
+		ExitCode:         taskExecResult.CurTaskExitCode,
+		Signal:           taskExecResult.CurTaskSignal,
+		KillSignal:       taskExecResult.CurTaskKillSignal,
+		ErrorClass:       taskExecResult.CurTaskErrorClass,
+		Artifacts:        taskExecResult.CurTaskArtifacts,
+		ScheduleTime:     taskExecResult.CurTaskExecStatus.CurTask.ScheduleTime,
+		RealScheduleTime: taskExecResult.CurTaskExecStatus.RealScheduleTime.UnixNano() / 1000 / 1000,
 		ExecTime:         taskExecResult.CurTaskExecStatus.ExecTime.UnixNano() / 1000 / 1000,
 		FinishTime:       taskExecResult.CurTaskExecStatus.FinishTime.UnixNano() / 1000 / 1000,
 	}
@@ -219,6 +497,42 @@ func (This *Scheduler) NewTaskLog(taskExecResult *common.TaskExecResult) (taskLo
 	return
 }
 
+// 根据任务执行的错误得到任务的最终状态
+func (This *Scheduler) FinalTaskState(taskErr error) common.TaskState {
+	switch taskErr {
+	case nil:
+		return common.TaskSucceeded
+	case common.ERROR_TIMEOUT:
+		return common.TaskTimedOut
+	case common.ERROR_KILLED:
+		return common.TaskKilled
+	default:
+		return common.TaskFailed
+	}
+}
+
+// 补全识别结果中的任务信息
+func (This *Scheduler) NewTaskResult(taskExecResult *common.TaskExecResult) (result *common.CrackResult) {
+	result = taskExecResult.CurTaskResult
+	result.TaskName = taskExecResult.CurTaskExecStatus.CurTask.TaskName
+	result.TaskId = taskExecResult.CurTaskExecStatus.CurTask.TaskId
+	result.TaskType = taskExecResult.CurTaskExecStatus.CurTask.TaskType
+	result.UserId = taskExecResult.CurTaskExecStatus.CurTask.UserId
+	result.CreateTime = taskExecResult.CurTaskExecStatus.FinishTime.UnixNano() / 1000 / 1000
+	return
+}
+
+// 警报消息中只保留输出的最后warnOutputTail字节(模型程序崩溃的原因通常在最后)
+const warnOutputTail = 4096
+
+// 输出的最后一部分
+func outputTail(output []byte) string {
+	if len(output) > warnOutputTail {
+		output = output[len(output) - warnOutputTail:]
+	}
+	return string(output)
+}
+
 // 创建新的警报消息
 func (This *Scheduler) NewWarnMessage(taskExecResult *common.TaskExecResult) (warnMessage *common.WarnMessage) {
 	warnMessage = &common.WarnMessage{
@@ -227,6 +541,11 @@ func (This *Scheduler) NewWarnMessage(taskExecResult *common.TaskExecResult) (wa
 		TaskType: 		  	taskExecResult.CurTaskExecStatus.CurTask.TaskType,
 		UserId:			  	taskExecResult.CurTaskExecStatus.CurTask.UserId,
 		Message:      		taskExecResult.CurTaskError.Error(),
+		ErrorClass:			taskExecResult.CurTaskErrorClass,
+		ExitCode:			taskExecResult.CurTaskExitCode,
+		Signal:				taskExecResult.CurTaskSignal,
+		Stdout:				outputTail(taskExecResult.CurTaskStdout),
+		Stderr:				outputTail(taskExecResult.CurTaskStderr),
 		GenerateTime: 		taskExecResult.CurTaskExecStatus.FinishTime.UnixNano(),
 	}
 	return
@@ -242,6 +561,7 @@ func init()  {
 		EventChan: make(chan *common.TaskEvent, 512),
 		ExecStatus: make(map[string]*common.TaskExecStatus, 512),
 		ExecResultChan: make(chan *common.TaskExecResult, 512),
+		Queue: newTaskQueue(),
 	}
 
 	// 启动任务调度器
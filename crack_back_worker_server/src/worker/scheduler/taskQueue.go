@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"time"
+)
+
+// 任务等待队列。到达的任务先进入队列，有空闲执行槽位时只从队首取任务去抢锁执行
+// 队首是得分最高的任务：得分 = 优先级 + 等待时间/AgingInterval - 该用户在本worker上正在执行的任务数*FairShareWeight
+// 得分相同时先到先执行，一个用户提交大量任务不会饿死其他用户，低优先级的任务等得足够久也会被执行
+
+type queuedTask struct {
+	task 				*common.Task
+	enqueueTime 		time.Time
+	seq 				uint64
+}
+
+type TaskQueue struct {
+	// 类型/用户id/任务名 --> 等待中的任务
+	tasks 				map[string]*queuedTask
+	seq 				uint64
+}
+
+func newTaskQueue() *TaskQueue {
+	return &TaskQueue{
+		tasks: make(map[string]*queuedTask, 512),
+	}
+}
+
+// 任务入队，已在队列中的任务只更新任务内容，保留原来的等待时间
+func (This *TaskQueue) Push(userTask string, task *common.Task) {
+	var (
+		queued 				*queuedTask
+		ok 					bool
+	)
+	if queued, ok = This.tasks[userTask]; ok {
+		queued.task = task
+		return
+	}
+	This.seq++
+	This.tasks[userTask] = &queuedTask{
+		task:        task,
+		enqueueTime: time.Now(),
+		seq:         This.seq,
+	}
+}
+
+// 从队列中移除任务，返回任务是否在队列中
+func (This *TaskQueue) Remove(userTask string) bool {
+	var (
+		ok 					bool
+	)
+	if _, ok = This.tasks[userTask]; ok {
+		delete(This.tasks, userTask)
+	}
+	return ok
+}
+
+func (This *TaskQueue) Len() int {
+	return len(This.tasks)
+}
+
+// 取出一个已经过期的任务，过期的任务不占用执行槽位，抢到锁的worker直接将其置为失败
+func (This *TaskQueue) PopExpired() (task *common.Task) {
+	var (
+		now 				int64
+		userTask 			string
+		queued 				*queuedTask
+	)
+	now = time.Now().UnixNano() / 1000 / 1000
+	for userTask, queued = range This.tasks {
+		if queued.task.Expired(now) {
+			delete(This.tasks, userTask)
+			return queued.task
+		}
+	}
+	return nil
+}
+
+// 任务的得分
+func (This *TaskQueue) score(queued *queuedTask, running map[int64]int, now time.Time) int {
+	var (
+		score 				int
+	)
+	score = queued.task.Priority
+	if config.Cfg.AgingInterval > 0 {
+		score += int(now.Sub(queued.enqueueTime) / config.Cfg.AgingInterval)
+	}
+	score -= running[queued.task.UserId] * config.Cfg.FairShareWeight
+	return score
+}
+
+// 取出队首任务，running为每个用户在本worker上正在执行的任务数，fullTypes中的任务类型已经达到并发上限，其任务留在队列中
+func (This *TaskQueue) Pop(running map[int64]int, fullTypes map[string]bool) (task *common.Task) {
+	var (
+		now 				time.Time
+		userTask 			string
+		queued 				*queuedTask
+		score 				int
+		headKey 			string
+		head 				*queuedTask
+		headScore 			int
+	)
+	now = time.Now()
+	for userTask, queued = range This.tasks {
+		// 还没到最早开始时间或重试时间
+		if queued.task.Dormant(now.UnixNano() / 1000 / 1000) || fullTypes[queued.task.TaskType] {
+			continue
+		}
+		score = This.score(queued, running, now)
+		if head == nil || score > headScore || (score == headScore && queued.seq < head.seq) {
+			headKey, head, headScore = userTask, queued, score
+		}
+	}
+	if head == nil {
+		return nil
+	}
+	delete(This.tasks, headKey)
+	return head.task
+}
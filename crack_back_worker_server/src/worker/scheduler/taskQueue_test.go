@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"testing"
+	"time"
+)
+
+// 按顺序取空队列，返回取出的任务名
+func popAll(queue *TaskQueue, running map[int64]int, fullTypes map[string]bool) (names []string) {
+	var (
+		task 				*common.Task
+	)
+	for task = queue.Pop(running, fullTypes); task != nil; task = queue.Pop(running, fullTypes) {
+		names = append(names, task.TaskName)
+	}
+	return
+}
+
+func equalNames(got []string, want []string) bool {
+	var (
+		index 				int
+	)
+	if len(got) != len(want) {
+		return false
+	}
+	for index = range got {
+		if got[index] != want[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func withQueueConfig(agingInterval time.Duration, fairShareWeight int) {
+	config.Cfg = &config.Config{
+		AgingInterval:   agingInterval,
+		FairShareWeight: fairShareWeight,
+	}
+}
+
+// 优先级高的先执行，优先级相同时先到先执行
+func TestTaskQueuePriority(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		names 				[]string
+	)
+	withQueueConfig(0, 0)
+	queue = newTaskQueue()
+	queue.Push("image/1/a", &common.Task{TaskName: "a", UserId: 1, Priority: 1})
+	queue.Push("image/1/b", &common.Task{TaskName: "b", UserId: 1, Priority: 5})
+	queue.Push("image/1/c", &common.Task{TaskName: "c", UserId: 1, Priority: 1})
+	queue.Push("image/1/d", &common.Task{TaskName: "d", UserId: 1, Priority: 9})
+
+	if names = popAll(queue, nil, nil); !equalNames(names, []string{"d", "b", "a", "c"}) {
+		t.Errorf("order = %v", names)
+	}
+}
+
+// 已在队列中的任务重新入队时保留原来的位置
+func TestTaskQueuePushKeepsPosition(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		names 				[]string
+	)
+	withQueueConfig(0, 0)
+	queue = newTaskQueue()
+	queue.Push("image/1/a", &common.Task{TaskName: "a", UserId: 1})
+	queue.Push("image/1/b", &common.Task{TaskName: "b", UserId: 1})
+	queue.Push("image/1/a", &common.Task{TaskName: "a", UserId: 1, Attempt: 1})
+
+	if queue.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", queue.Len())
+	}
+	if names = popAll(queue, nil, nil); !equalNames(names, []string{"a", "b"}) {
+		t.Errorf("order = %v", names)
+	}
+}
+
+// 每等待AgingInterval得分加1，低优先级的任务等得足够久会排到前面
+func TestTaskQueueAging(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		names 				[]string
+	)
+	withQueueConfig(10 * time.Second, 0)
+	queue = newTaskQueue()
+	queue.Push("image/1/old", &common.Task{TaskName: "old", UserId: 1, Priority: 0})
+	queue.Push("image/1/new", &common.Task{TaskName: "new", UserId: 1, Priority: 2})
+
+	// 等待了25s，得分为0+2，与new相同时先到先执行
+	queue.tasks["image/1/old"].enqueueTime = time.Now().Add(-25 * time.Second)
+	if names = popAll(queue, nil, nil); !equalNames(names, []string{"old", "new"}) {
+		t.Errorf("order = %v", names)
+	}
+
+	// 等待时间不够时仍然按优先级
+	queue.Push("image/1/old", &common.Task{TaskName: "old", UserId: 1, Priority: 0})
+	queue.Push("image/1/new", &common.Task{TaskName: "new", UserId: 1, Priority: 2})
+	queue.tasks["image/1/old"].enqueueTime = time.Now().Add(-15 * time.Second)
+	if names = popAll(queue, nil, nil); !equalNames(names, []string{"new", "old"}) {
+		t.Errorf("order = %v", names)
+	}
+}
+
+// 正在执行的任务越多的用户得分越低
+func TestTaskQueueFairShare(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		names 				[]string
+		running 			map[int64]int
+	)
+	withQueueConfig(0, 2)
+	queue = newTaskQueue()
+	queue.Push("image/1/a", &common.Task{TaskName: "a", UserId: 1, Priority: 3})
+	queue.Push("image/2/b", &common.Task{TaskName: "b", UserId: 2, Priority: 0})
+	queue.Push("image/3/c", &common.Task{TaskName: "c", UserId: 3, Priority: 1})
+
+	// 用户1的得分为3-2*2=-1，用户2为0-0=0，用户3为1-1*2=-1
+	running = map[int64]int{1: 2, 3: 1}
+	if names = popAll(queue, running, nil); !equalNames(names, []string{"b", "a", "c"}) {
+		t.Errorf("order = %v", names)
+	}
+}
+
+// 休眠中的任务和达到并发上限的任务类型留在队列中
+func TestTaskQueueSkipsDormantAndFullTypes(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		now 				int64
+		names 				[]string
+	)
+	withQueueConfig(0, 0)
+	now = time.Now().UnixNano() / 1000 / 1000
+	queue = newTaskQueue()
+	queue.Push("image/1/later", &common.Task{TaskName: "later", TaskType: "image", UserId: 1, Priority: 9, NotBefore: now + 60000})
+	queue.Push("image/1/retry", &common.Task{TaskName: "retry", TaskType: "image", UserId: 1, Priority: 9, RetryAt: now + 60000})
+	queue.Push("video/1/full", &common.Task{TaskName: "full", TaskType: "video", UserId: 1, Priority: 9})
+	queue.Push("image/1/ready", &common.Task{TaskName: "ready", TaskType: "image", UserId: 1})
+
+	if names = popAll(queue, nil, map[string]bool{"video": true}); !equalNames(names, []string{"ready"}) {
+		t.Errorf("order = %v", names)
+	}
+	if queue.Len() != 3 {
+		t.Errorf("Len = %d, want 3", queue.Len())
+	}
+}
+
+// 过期的任务不按得分，直接取出
+func TestTaskQueuePopExpired(t *testing.T) {
+	var (
+		queue 				*TaskQueue
+		now 				int64
+		task 				*common.Task
+	)
+	withQueueConfig(0, 0)
+	now = time.Now().UnixNano() / 1000 / 1000
+	queue = newTaskQueue()
+	queue.Push("image/1/a", &common.Task{TaskName: "a", UserId: 1, Deadline: now + 60000})
+	queue.Push("image/1/b", &common.Task{TaskName: "b", UserId: 1, Deadline: now - 1})
+
+	if task = queue.PopExpired(); task == nil || task.TaskName != "b" {
+		t.Fatalf("PopExpired = %v, want b", task)
+	}
+	if task = queue.PopExpired(); task != nil {
+		t.Errorf("PopExpired = %v, want nil", task.TaskName)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("Len = %d, want 1", queue.Len())
+	}
+}
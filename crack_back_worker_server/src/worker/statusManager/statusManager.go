@@ -0,0 +1,286 @@
+package statusManager
+
+import (
+	"context"
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/register"
+	"crack_back/src/worker/taskLogger"
+	"encoding/json"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 任务状态管理器。每个任务只有一条权威的状态记录(StatusDir/任务id)
+// 所有状态修改都要经过状态机校验，并以修改版本号做CAS，成功后镜像到MongoDB
+
+// CAS冲突时的最大重试次数
+const maxCasRetry = 5
+
+type StatusManager struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 任务状态在etcd中的key
+func statusKey(taskId int64) string {
+	return path.Join(config.Cfg.StatusDir, strconv.FormatInt(taskId, 10))
+}
+
+// 读取任务状态，返回该记录的修改版本号(记录不存在时为0)
+func (This *StatusManager) GetTaskStatus(taskId int64) (status *common.TaskStatus, modRevision int64, err error) {
+	var (
+		op 					clientv3.Op
+		opResp 				clientv3.OpResponse
+	)
+	op = clientv3.OpGet(statusKey(taskId))
+	if opResp, err = This.kv.Do(context.TODO(), op); err != nil {
+		return
+	}
+	if len(opResp.Get().Kvs) == 0 {
+		return nil, 0, nil
+	}
+	status = &common.TaskStatus{}
+	if err = json.Unmarshal(opResp.Get().Kvs[0].Value, status); err != nil {
+		return nil, 0, err
+	}
+	return status, opResp.Get().Kvs[0].ModRevision, nil
+}
+
+// 把任务状态转换为to，taskErr为任务失败的原因
+func (This *StatusManager) Transition(task *common.Task, to common.TaskState, taskErr error) (err error) {
+	return This.transition(task, "", to, taskErr)
+}
+
+// 强杀还没有被任何worker抢占的任务。已经被抢占的任务由执行它的worker取消执行后转换状态，
+// 其他worker不能把它置为killed，否则执行中的任务会被提前结束
+func (This *StatusManager) KillPending(task *common.Task) (err error) {
+	return This.transition(task, common.TaskPending, common.TaskKilled, common.ERROR_KILLED)
+}
+
+// 当前状态为from(为空时不限制)时把任务状态转换为to，与读取到的状态在同一次CAS中校验
+func (This *StatusManager) transition(task *common.Task, from common.TaskState, to common.TaskState, taskErr error) (err error) {
+	var (
+		status 				*common.TaskStatus
+		modRevision 		int64
+		statusValue 		[]byte
+		txnResp 			*clientv3.TxnResponse
+		retry 				int
+		now 				int64
+	)
+	// 没有任务id的任务没有状态记录
+	if task.TaskId == 0 {
+		return nil
+	}
+
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, modRevision, err = This.GetTaskStatus(task.TaskId); err != nil {
+			return
+		}
+		// master没有创建状态记录时视为pending
+		if status == nil {
+			status = &common.TaskStatus{
+				TaskType: task.TaskType,
+				UserId:   task.UserId,
+				TaskName: task.TaskName,
+				TaskId:   task.TaskId,
+				State:    common.TaskPending,
+			}
+		}
+		if (from != "" && status.State != from) || !status.State.CanTransitionTo(to) {
+			return common.ERROR_STATE_TRANSITION
+		}
+
+		now = time.Now().UnixNano() / 1000 / 1000
+		status.State = to
+		status.UpdateTime = now
+		status.Attempt = task.Attempt
+		if len(task.OutputRefs) != 0 {
+			status.OutputRefs = task.OutputRefs
+		}
+		if taskErr != nil {
+			status.Error = taskErr.Error()
+		}
+		switch to {
+		case common.TaskClaimed:
+			status.ClaimTime = now
+			status.Worker = register.WorkerRegister.WorkerIP()
+		case common.TaskRunning:
+			status.StartTime = now
+			status.Progress = nil
+		case common.TaskSucceeded, common.TaskFailed, common.TaskKilled, common.TaskTimedOut:
+			status.FinishTime = now
+		}
+
+		if statusValue, err = json.Marshal(status); err != nil {
+			return
+		}
+
+		// 记录自读取后没有被修改过才写入(记录不存在时ModRevision为0)
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(task.TaskId)), "=", modRevision)).Then(
+			clientv3.OpPut(statusKey(task.TaskId), string(statusValue))).Commit(); err != nil {
+			return
+		}
+		if txnResp.Succeeded {
+			// 镜像到MongoDB，master重启或etcd中的记录被清理后仍然可以查询
+			status.Revision = txnResp.Header.Revision
+			taskLogger.Logger.PushTaskStatus(status)
+			return nil
+		}
+	}
+	return common.ERROR_STATE_CONFLICT
+}
+
+// 更新执行中的任务的进度，任务已经不在running状态时不再更新
+// 进度更新频繁，不镜像到MongoDB，最后的进度随任务结束时的状态转换一起镜像
+func (This *StatusManager) UpdateProgress(task *common.Task, progress *common.TaskProgress) (err error) {
+	var (
+		status 				*common.TaskStatus
+		modRevision 		int64
+		statusValue 		[]byte
+		txnResp 			*clientv3.TxnResponse
+		retry 				int
+	)
+	if task.TaskId == 0 {
+		return nil
+	}
+
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, modRevision, err = This.GetTaskStatus(task.TaskId); err != nil {
+			return
+		}
+		if status == nil || status.State != common.TaskRunning || status.Attempt != task.Attempt {
+			return nil
+		}
+
+		status.Progress = progress
+		if statusValue, err = json.Marshal(status); err != nil {
+			return
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(task.TaskId)), "=", modRevision)).Then(
+			clientv3.OpPut(statusKey(task.TaskId), string(statusValue))).Commit(); err != nil {
+			return
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+	}
+	return common.ERROR_STATE_CONFLICT
+}
+
+// 任务在etcd中的key
+func taskKey(task *common.Task) string {
+	return path.Join(config.Cfg.TaskDir, task.TaskType, strconv.FormatInt(task.UserId, 10), task.TaskName)
+}
+
+// 失败的任务重新入队：状态回到pending，同时把下一次执行的任务(next，重试次数和重试时间已更新)写回任务目录，
+// 所有worker都会收到该任务并在重试时间到达后重新抢占。任务已经被删除时不再写回
+func (This *StatusManager) Retry(task *common.Task, taskErr error, next *common.Task) (err error) {
+	return This.requeue(task, taskErr, next, false)
+}
+
+// 放弃本worker的执行(锁的租约失效)：任务原样重新入队，不占用重试次数
+// 只有状态记录中的执行者还是本worker时才入队，其他worker已经抢占时不影响它的执行
+func (This *StatusManager) Abandon(task *common.Task, taskErr error) (err error) {
+	return This.requeue(task, taskErr, task, true)
+}
+
+// 任务重新入队，own为true时只入队本worker抢占的任务
+func (This *StatusManager) requeue(task *common.Task, taskErr error, next *common.Task, own bool) (err error) {
+	var (
+		status 				*common.TaskStatus
+		modRevision 		int64
+		statusValue 		[]byte
+		taskValue 			[]byte
+		txnResp 			*clientv3.TxnResponse
+		retry 				int
+	)
+	if taskValue, err = json.Marshal(next); err != nil {
+		return
+	}
+
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, modRevision, err = This.GetTaskStatus(task.TaskId); err != nil {
+			return
+		}
+		if status == nil || !status.State.CanTransitionTo(common.TaskPending) ||
+			(own && status.Worker != register.WorkerRegister.WorkerIP()) {
+			return common.ERROR_STATE_TRANSITION
+		}
+
+		status.State = common.TaskPending
+		status.UpdateTime = time.Now().UnixNano() / 1000 / 1000
+		status.Attempt = next.Attempt
+		status.Progress = nil
+		if taskErr != nil {
+			status.Error = taskErr.Error()
+		}
+		if statusValue, err = json.Marshal(status); err != nil {
+			return
+		}
+
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(task.TaskId)), "=", modRevision)).Then(
+			clientv3.OpPut(statusKey(task.TaskId), string(statusValue)),
+			clientv3.OpTxn(
+				[]clientv3.Cmp{clientv3.Compare(clientv3.Version(taskKey(task)), ">", 0)},
+				[]clientv3.Op{clientv3.OpPut(taskKey(task), string(taskValue))},
+				nil)).Commit(); err != nil {
+			return
+		}
+		if txnResp.Succeeded {
+			status.Revision = txnResp.Header.Revision
+			taskLogger.Logger.PushTaskStatus(status)
+			return nil
+		}
+	}
+	return common.ERROR_STATE_CONFLICT
+}
+
+// 分配模式下删除Leader分配给本worker的记录，Leader不再把该任务同步给本worker
+func (This *StatusManager) ReleaseAssignment(task *common.Task) (err error) {
+	if !config.Cfg.AssignMode {
+		return nil
+	}
+	_, err = This.kv.Delete(context.TODO(), path.Join(config.Cfg.AssignDir, register.WorkerRegister.WorkerIP(),
+		task.TaskType, strconv.FormatInt(task.UserId, 10), task.TaskName))
+	return
+}
+
+// 状态管理器单例
+var (
+	SM 					*StatusManager
+)
+
+func InitStatusManager() (err error) {
+	if SM == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		SM = &StatusManager{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package streamer
+
+import (
+	"bufio"
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/logger"
+	"crack_back/src/worker/statusManager"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 执行进度。模型程序向进度管道(子进程中的文件描述符由环境变量CRACK_PROGRESS_FD给出)逐行写入JSON，
+// 每行更新其中出现的字段：progress(完成比例0-1)、stage(阶段名称)、metrics(数值型的中间指标，按名称合并)
+// 解析后的进度最多每ProgressInterval写入一次任务状态记录，管道关闭时写入最后的进度
+
+// 进度管道在子进程中的环境变量
+const progressFdEnv = "CRACK_PROGRESS_FD"
+
+// 阶段名称的最大字节数和中间指标的最大个数
+const (
+	maxStageBytes 			int = 128
+	maxMetrics 				int = 64
+)
+
+// 模型程序报告的一行进度，没有出现的字段保持不变
+type progressLine struct {
+	Progress 				*float64 			`json:"progress"`
+	Stage 					*string 			`json:"stage"`
+	Metrics 				map[string]float64 	`json:"metrics"`
+}
+
+// 一次执行的进度报告
+type ProgressReader struct {
+	task 				*common.Task
+	reader 				*os.File
+
+	mutex 				sync.Mutex
+	progress 			*common.TaskProgress
+	dirty 				bool 				// 上次写入后是否有新的报告
+
+	done 				chan struct{} 		// 管道已经读完并写入了最后的进度
+}
+
+// 为cmd创建进度管道，写端作为子进程的一个额外的文件描述符，在cmd启动后由父进程关闭
+func OpenProgress(task *common.Task, cmd *exec.Cmd) (progressReader *ProgressReader, err error) {
+	var (
+		reader 				*os.File
+		writer 				*os.File
+	)
+	if reader, writer, err = os.Pipe(); err != nil {
+		return
+	}
+	// ExtraFiles中的第i个文件在子进程中的文件描述符为3+i
+	cmd.ExtraFiles = append(cmd.ExtraFiles, writer)
+	cmd.Env = append(cmd.Env, progressFdEnv + "=" + strconv.Itoa(2 + len(cmd.ExtraFiles)))
+
+	progressReader = &ProgressReader{
+		task:   task,
+		reader: reader,
+		done:   make(chan struct{}),
+	}
+	go progressReader.loop()
+	return progressReader, nil
+}
+
+// 逐行读取管道直到写端全部关闭，同时按间隔写入进度
+func (This *ProgressReader) loop() {
+	var (
+		bufReader 			*bufio.Reader
+		line 				[]byte
+		isPrefix 			bool
+		skip 				bool
+		stop 				chan struct{}
+		publisher 			sync.WaitGroup
+		err 				error
+	)
+	defer close(This.done)
+
+	stop = make(chan struct{})
+	publisher.Add(1)
+	go func() {
+		var (
+			ticker 				*time.Ticker
+		)
+		defer publisher.Done()
+		ticker = time.NewTicker(config.Cfg.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				This.publish()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	bufReader = bufio.NewReaderSize(This.reader, config.Cfg.StreamMaxLineBytes)
+	for {
+		if line, isPrefix, err = bufReader.ReadLine(); err != nil {
+			break
+		}
+		// 超过MaxLineBytes的行整行丢弃
+		if isPrefix || skip {
+			skip = isPrefix
+			continue
+		}
+		This.update(line)
+	}
+	// 等待超时后管道被关闭时不是错误
+	if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+		logger.Logger.WarnLog("读取任务", This.task.TaskId, "的进度失败:", err)
+	}
+	_ = This.reader.Close()
+
+	close(stop)
+	publisher.Wait()
+	This.publish()
+}
+
+// 解析一行进度报告，不合法的行被忽略
+func (This *ProgressReader) update(line []byte) {
+	var (
+		report 				progressLine
+		name 				string
+		value 				float64
+		ok 					bool
+	)
+	if json.Unmarshal(line, &report) != nil {
+		return
+	}
+
+	This.mutex.Lock()
+	defer This.mutex.Unlock()
+	if This.progress == nil {
+		This.progress = &common.TaskProgress{Metrics: make(map[string]float64)}
+	}
+	if report.Progress != nil {
+		This.progress.Progress = *report.Progress
+		if This.progress.Progress < 0 {
+			This.progress.Progress = 0
+		} else if This.progress.Progress > 1 {
+			This.progress.Progress = 1
+		}
+	}
+	if report.Stage != nil {
+		This.progress.Stage = *report.Stage
+		if len(This.progress.Stage) > maxStageBytes {
+			This.progress.Stage = This.progress.Stage[:maxStageBytes]
+		}
+	}
+	for name, value = range report.Metrics {
+		if _, ok = This.progress.Metrics[name]; !ok && len(This.progress.Metrics) >= maxMetrics {
+			continue
+		}
+		This.progress.Metrics[name] = value
+	}
+	This.progress.UpdateTime = time.Now().UnixNano() / 1000 / 1000
+	This.dirty = true
+}
+
+// 有新的报告时写入任务状态记录
+func (This *ProgressReader) publish() {
+	var (
+		progress 			common.TaskProgress
+		name 				string
+		value 				float64
+		err 				error
+	)
+	This.mutex.Lock()
+	if !This.dirty {
+		This.mutex.Unlock()
+		return
+	}
+	This.dirty = false
+	progress = *This.progress
+	progress.Metrics = make(map[string]float64, len(This.progress.Metrics))
+	for name, value = range This.progress.Metrics {
+		progress.Metrics[name] = value
+	}
+	This.mutex.Unlock()
+
+	if err = statusManager.SM.UpdateProgress(This.task, &progress); err != nil {
+		logger.Logger.WarnLog("更新任务", This.task.TaskId, "的进度失败:", err)
+	}
+}
+
+// 等待最后的进度写入。模型程序退出后它启动的后台进程可能还持有管道的写端，最多等待ProgressInterval
+func (This *ProgressReader) Close() {
+	var (
+		timer 				*time.Timer
+	)
+	timer = time.NewTimer(config.Cfg.ProgressInterval)
+	defer timer.Stop()
+	select {
+	case <-This.done:
+	case <-timer.C:
+		_ = This.reader.Close()
+		<-This.done
+	}
+}
@@ -0,0 +1,282 @@
+package streamer
+
+import (
+	"bytes"
+	"context"
+	"crack_back/src/common"
+	"crack_back/src/config"
+	"crack_back/src/worker/logger"
+	"crack_back/src/worker/register"
+	"encoding/json"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 实时输出发布器。执行器把模型程序的标准输出和标准错误逐行写入每个任务的有界缓冲区(只保留最近的BufferLines行)，
+// 发布器定期把有变化的缓冲区写入StreamDir/任务id，master监听该key推给客户端
+// 执行中的输出绑定一个自动续租的租约，worker退出时自动删除；执行结束后改为绑定Retention的租约，供客户端读完最后的输出
+
+type Streamer struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+	lease 				clientv3.Lease
+
+	mutex 				sync.Mutex
+	streams 			map[int64]*liveStream		// 任务id --> 正在执行的任务的输出
+	leaseID 			clientv3.LeaseID			// 执行中的输出共用的租约，为0时还没有申请或者已经失效
+}
+
+// 一个正在执行的任务的输出
+type liveStream struct {
+	stream 				*common.TaskStream
+	seq 				int64 			// 最后一行的行号
+	dirty 				bool 			// 上次发布后是否有新的输出
+}
+
+// 输出在etcd中的key
+func streamKey(taskId int64) string {
+	return path.Join(config.Cfg.StreamDir, strconv.FormatInt(taskId, 10))
+}
+
+// 开始收集任务的输出(没有任务id的任务不收集)
+func (This *Streamer) Open(task *common.Task) {
+	if task.TaskId == 0 {
+		return
+	}
+	This.mutex.Lock()
+	defer This.mutex.Unlock()
+	This.streams[task.TaskId] = &liveStream{
+		stream: &common.TaskStream{
+			TaskId:  task.TaskId,
+			Attempt: task.Attempt,
+			Worker:  register.WorkerRegister.WorkerIP(),
+		},
+		dirty: true,
+	}
+}
+
+// 追加一行输出，超过BufferLines时丢弃最早的行
+func (This *Streamer) appendLine(taskId int64, stream string, text string) {
+	var (
+		live 				*liveStream
+		ok 					bool
+	)
+	This.mutex.Lock()
+	defer This.mutex.Unlock()
+	if live, ok = This.streams[taskId]; !ok {
+		return
+	}
+	live.seq++
+	live.stream.Lines = append(live.stream.Lines, &common.StreamLine{
+		Seq:    live.seq,
+		Stream: stream,
+		Text:   text,
+		Time:   time.Now().UnixNano() / 1000 / 1000,
+	})
+	if len(live.stream.Lines) > config.Cfg.StreamBufferLines {
+		live.stream.Lines = live.stream.Lines[len(live.stream.Lines) - config.Cfg.StreamBufferLines:]
+	}
+	live.dirty = true
+}
+
+// 结束收集任务的输出，立即发布最后的输出
+func (This *Streamer) Close(task *common.Task) {
+	var (
+		live 				*liveStream
+		ok 					bool
+		streamValue 		[]byte
+		leaseGrantResp 		*clientv3.LeaseGrantResponse
+		err 				error
+	)
+	This.mutex.Lock()
+	if live, ok = This.streams[task.TaskId]; ok {
+		delete(This.streams, task.TaskId)
+		live.stream.Done = true
+		live.stream.UpdateTime = time.Now().UnixNano() / 1000 / 1000
+		streamValue, err = json.Marshal(live.stream)
+	}
+	This.mutex.Unlock()
+	if !ok || err != nil {
+		return
+	}
+
+	if leaseGrantResp, err = This.lease.Grant(context.TODO(), config.Cfg.StreamRetention); err != nil {
+		logger.Logger.WarnLog("发布任务输出失败, task_id=", task.TaskId, "err=", err)
+		return
+	}
+	if _, err = This.kv.Put(context.TODO(), streamKey(task.TaskId), string(streamValue), clientv3.WithLease(leaseGrantResp.ID)); err != nil {
+		logger.Logger.WarnLog("发布任务输出失败, task_id=", task.TaskId, "err=", err)
+	}
+}
+
+// 获取执行中的输出共用的租约，还没有申请或者已经失效时重新申请并自动续租
+func (This *Streamer) liveLease() (leaseID clientv3.LeaseID, err error) {
+	var (
+		leaseGrantResp 			*clientv3.LeaseGrantResponse
+		leaseKeepAliveRespChan	<-chan *clientv3.LeaseKeepAliveResponse
+	)
+	This.mutex.Lock()
+	defer This.mutex.Unlock()
+	if This.leaseID != 0 {
+		return This.leaseID, nil
+	}
+
+	if leaseGrantResp, err = This.lease.Grant(context.TODO(), 10); err != nil {
+		return
+	}
+	leaseID = leaseGrantResp.ID
+	if leaseKeepAliveRespChan, err = This.lease.KeepAlive(context.TODO(), leaseID); err != nil {
+		_, _ = This.lease.Revoke(context.TODO(), leaseID)
+		return
+	}
+	This.leaseID = leaseID
+
+	// 续租应答通道关闭说明租约已经失效，下次发布时重新申请
+	go func() {
+		for range leaseKeepAliveRespChan {
+		}
+		This.mutex.Lock()
+		if This.leaseID == leaseID {
+			This.leaseID = 0
+		}
+		This.mutex.Unlock()
+	}()
+	return leaseID, nil
+}
+
+// 发布所有有变化的输出
+func (This *Streamer) publish() {
+	var (
+		live 				*liveStream
+		values 				map[int64][]byte
+		taskId 				int64
+		streamValue 		[]byte
+		leaseID 			clientv3.LeaseID
+		err 				error
+	)
+	This.mutex.Lock()
+	values = make(map[int64][]byte)
+	for taskId, live = range This.streams {
+		if !live.dirty {
+			continue
+		}
+		live.stream.UpdateTime = time.Now().UnixNano() / 1000 / 1000
+		if streamValue, err = json.Marshal(live.stream); err != nil {
+			continue
+		}
+		values[taskId] = streamValue
+		live.dirty = false
+	}
+	This.mutex.Unlock()
+	if len(values) == 0 {
+		return
+	}
+
+	if leaseID, err = This.liveLease(); err != nil {
+		logger.Logger.WarnLog("申请输出租约失败:", err)
+		return
+	}
+	for taskId, streamValue = range values {
+		if _, err = This.kv.Put(context.TODO(), streamKey(taskId), string(streamValue), clientv3.WithLease(leaseID)); err != nil {
+			logger.Logger.WarnLog("发布任务输出失败, task_id=", taskId, "err=", err)
+		}
+	}
+}
+
+// 定期发布
+func (This *Streamer) loop() {
+	var (
+		ticker 				*time.Ticker
+	)
+	ticker = time.NewTicker(config.Cfg.StreamInterval)
+	for range ticker.C {
+		This.publish()
+	}
+}
+
+// 逐行写入某个任务的一个输出流(作为cmd.Stdout/cmd.Stderr)，不完整的行留到下次写入，
+// 超过MaxLineBytes的行截断为多行
+type LineWriter struct {
+	streamer 			*Streamer
+	taskId 				int64
+	stream 				string
+	partial 			[]byte
+}
+
+// 新建任务输出流的LineWriter
+func (This *Streamer) NewLineWriter(task *common.Task, stream string) *LineWriter {
+	return &LineWriter{
+		streamer: This,
+		taskId:   task.TaskId,
+		stream:   stream,
+	}
+}
+
+func (This *LineWriter) Write(p []byte) (n int, err error) {
+	var (
+		index 				int
+	)
+	This.partial = append(This.partial, p...)
+	for {
+		if index = bytes.IndexByte(This.partial, '\n'); index < 0 {
+			if len(This.partial) < config.Cfg.StreamMaxLineBytes {
+				break
+			}
+			index = config.Cfg.StreamMaxLineBytes
+			This.streamer.appendLine(This.taskId, This.stream, string(This.partial[:index]))
+			This.partial = This.partial[index:]
+			continue
+		}
+		This.streamer.appendLine(This.taskId, This.stream, string(bytes.TrimSuffix(This.partial[:index], []byte("\r"))))
+		This.partial = This.partial[index+1:]
+	}
+	return len(p), nil
+}
+
+// 程序退出后写入最后一行不完整的输出
+func (This *LineWriter) Flush() {
+	if len(This.partial) != 0 {
+		This.streamer.appendLine(This.taskId, This.stream, string(This.partial))
+		This.partial = nil
+	}
+}
+
+// 实时输出发布器单例
+var (
+	Stream 				*Streamer
+)
+
+func InitStreamer() (err error) {
+	if Stream == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		Stream = &Streamer{
+			client:  client,
+			kv:      clientv3.NewKV(client),
+			lease:   clientv3.NewLease(client),
+			streams: make(map[int64]*liveStream),
+		}
+		go Stream.loop()
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"crack_back/src/common"
 	"crack_back/src/config"
+	"crack_back/src/worker/logger"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
@@ -12,7 +14,11 @@ import (
 type TaskLogger struct {
 	mongoClient 				*mongo.Client
 	mongoCollection 			*mongo.Collection
+	resultCollection 			*mongo.Collection
+	statusCollection 			*mongo.Collection
 	taskLogChan 				chan *common.TaskLog
+	taskResultChan 				chan *common.CrackResult
+	taskStatusChan 				chan *common.TaskStatus
 	batchTimeOutChan			chan *common.TaskLogBatch
 
 	cancelCtx					context.Context
@@ -25,6 +31,7 @@ func (This *TaskLogger) loop () {
 		taskLogBatch 			*common.TaskLogBatch
 		taskLog 				*common.TaskLog
 		batchTimeOut			*common.TaskLogBatch
+		taskResult 				*common.CrackResult
 	)
 
 	for {
@@ -63,6 +70,11 @@ func (This *TaskLogger) loop () {
 				taskLogBatch = nil
 			}
 			break
+
+		case taskResult = <-This.taskResultChan:
+			// 识别结果需要尽快能被查询到，不做批量落盘
+			go This.resultSink(taskResult)
+			break
 		}
 	}
 }
@@ -74,11 +86,44 @@ func (This *TaskLogger) logSink (taskLogBatch *common.TaskLogBatch)  {
 	}
 }
 
+// 识别结果落盘(同一个任务重复执行时覆盖旧的结果)
+func (This *TaskLogger) resultSink (taskResult *common.CrackResult)  {
+	var (
+		err 					error
+	)
+	if _, err = This.resultCollection.ReplaceOne(context.TODO(), bson.M{"task_id": taskResult.TaskId}, taskResult, options.Replace().SetUpsert(true)); err != nil{
+		logger.Logger.WarnLog("识别结果落盘失败, task_id=", taskResult.TaskId, "err=", err)
+	}
+}
+
+// 任务状态镜像循环。同一个任务的状态必须按顺序写入，故而由一个协程串行落盘
+func (This *TaskLogger) statusLoop () {
+	var (
+		err 					error
+		taskStatus 				*common.TaskStatus
+	)
+	for taskStatus = range This.taskStatusChan {
+		if _, err = This.statusCollection.ReplaceOne(context.TODO(), bson.M{"task_id": taskStatus.TaskId}, taskStatus, options.Replace().SetUpsert(true)); err != nil{
+			logger.Logger.WarnLog("任务状态落盘失败, task_id=", taskStatus.TaskId, "err=", err)
+		}
+	}
+}
+
 // 写入一个日志
 func (This *TaskLogger) PushTaskLog (taskLog *common.TaskLog)  {
 	This.taskLogChan <- taskLog
 }
 
+// 写入一个识别结果
+func (This *TaskLogger) PushTaskResult (taskResult *common.CrackResult)  {
+	This.taskResultChan <- taskResult
+}
+
+// 写入一个任务状态
+func (This *TaskLogger) PushTaskStatus (taskStatus *common.TaskStatus)  {
+	This.taskStatusChan <- taskStatus
+}
+
 // 日志记录器单例
 var (
 	Logger				*TaskLogger
@@ -103,7 +148,11 @@ func InitLogger() (err error) {
 		Logger = &TaskLogger{
 			mongoClient:      client,
 			mongoCollection:  client.Database(config.Cfg.DatabaseName).Collection(config.Cfg.Collection),
+			resultCollection: client.Database(config.Cfg.DatabaseName).Collection(config.Cfg.ResultCollection),
+			statusCollection: client.Database(config.Cfg.DatabaseName).Collection(config.Cfg.StatusCollection),
 			taskLogChan:      make(chan *common.TaskLog, 1024),
+			taskResultChan:   make(chan *common.CrackResult, 1024),
+			taskStatusChan:   make(chan *common.TaskStatus, 1024),
 			batchTimeOutChan: make(chan *common.TaskLogBatch, 1024),
 			cancelCtx:        ctx,
 			cancelFunc:       cancelFunc,
@@ -111,6 +160,8 @@ func InitLogger() (err error) {
 
 		// 写日志
 		go Logger.loop()
+		// 镜像任务状态
+		go Logger.statusLoop()
 	}
 	return nil
 }
@@ -6,11 +6,13 @@ import (
 	"crack_back/src/config"
 	"crack_back/src/worker/lock"
 	"crack_back/src/worker/logger"
+	"crack_back/src/worker/register"
 	"crack_back/src/worker/scheduler"
 	"encoding/json"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"path"
 	"strings"
 )
 
@@ -23,7 +25,16 @@ type TaskManager struct {
 }
 
 // 该客户端绑定的方法
+// 监听任务：抢锁模式下监听整个任务目录，分配模式下只监听Leader分配给本worker的任务
 func (This *TaskManager) WatchTasks() (err error) {
+	if config.Cfg.AssignMode {
+		return This.watchTaskDir(path.Join(config.Cfg.AssignDir, register.WorkerRegister.WorkerIP()) + "/")
+	}
+	return This.watchTaskDir(config.Cfg.TaskDir)
+}
+
+// 监听taskDir目录下的任务，key为taskDir/任务类型/用户id/任务名
+func (This *TaskManager) watchTaskDir(taskDir string) (err error) {
 	var (
 		Op 					clientv3.Op
 		OpResp 				clientv3.OpResponse
@@ -34,7 +45,7 @@ func (This *TaskManager) WatchTasks() (err error) {
 		taskEvent			*common.TaskEvent
 	)
 	// 获取etcd中/crack/tasks/目录下的所有任务，以及当前集群的revision
-	Op = clientv3.OpGet(config.Cfg.TaskDir, clientv3.WithPrefix())
+	Op = clientv3.OpGet(taskDir, clientv3.WithPrefix())
 	if OpResp, err = This.KV.Do(context.TODO(), Op); err != nil{
 		return
 	}
@@ -66,7 +77,7 @@ func (This *TaskManager) WatchTasks() (err error) {
 			taskBaseName		string
 		)
 		watchStartRevision = OpResp.Get().Header.Revision + 1
-		watchRespChan = This.Watcher.Watch(context.TODO(), config.Cfg.TaskDir, clientv3.WithRev(watchStartRevision), clientv3.WithPrefix())
+		watchRespChan = This.Watcher.Watch(context.TODO(), taskDir, clientv3.WithRev(watchStartRevision), clientv3.WithPrefix())
 
 		// 遍历watch的应答
 		for watchResp = range watchRespChan{
@@ -90,7 +101,7 @@ func (This *TaskManager) WatchTasks() (err error) {
 				// 删除任务
 				case clientv3.EventTypeDelete:
 					// 获取任务名(不包括目录名),推给scheduler调度器一个删除事件
-					taskBaseName = strings.TrimPrefix( string(watchEvent.Kv.Key), config.Cfg.TaskDir)
+					taskBaseName = strings.TrimPrefix( string(watchEvent.Kv.Key), taskDir)
 					task = &common.Task{TaskName: taskBaseName}
 					// 推给scheduler调度器一个删除事件
 					taskEvent = &common.TaskEvent{
@@ -115,6 +126,7 @@ func (This *TaskManager) WatchKiller() (err error) {
 	// 开一个协程持续监听该目录的的变化事件
 	go func() {
 		var (
+			err 						error
 			watchRespChan				clientv3.WatchChan
 			watchResp					clientv3.WatchResponse
 			watchEvent					*clientv3.Event
@@ -131,8 +143,15 @@ func (This *TaskManager) WatchKiller() (err error) {
 				// 强杀任务
 				case clientv3.EventTypePut:
 					// 获取任务名(不包括目录名),推给scheduler调度器一个强杀事件
+					// value中是被强杀的任务(带有任务id)，TaskName统一为 任务类型/用户id/任务名
 					taskBaseName = strings.TrimPrefix( string(watchEvent.Kv.Key), config.Cfg.KillerDir)
-					task = &common.Task{TaskName: taskBaseName}
+					task = &common.Task{}
+					if len(watchEvent.Kv.Value) != 0 {
+						if err = json.Unmarshal(watchEvent.Kv.Value, task); err != nil{
+							logger.Logger.InfoLog("WatchKiller反序列化错误...已丢弃该错误:", err.Error())
+						}
+					}
+					task.TaskName = taskBaseName
 					// 推给scheduler调度器一个强杀事件
 					taskEvent = &common.TaskEvent{
 						CurEvent: common.EventKill,
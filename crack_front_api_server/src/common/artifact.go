@@ -0,0 +1,16 @@
+package common
+
+// 任务执行产生的产物文件(如掩膜、叠加图、CSV)：模型程序写入本次执行的工作目录，
+// 执行结束后由worker把符合模型程序配置(Artifacts)的文件存入存储器，记录在该次执行的日志中
+type Artifact struct {
+	Name 					string 		`bson:"name" json:"name"`				// 相对于工作目录的路径
+	Ref 					string 		`bson:"ref" json:"ref"`					// 存储器中的引用
+	Size 					int64 		`bson:"size" json:"size"`				// 大小(字节)
+}
+
+// 一次执行的产物文件
+type TaskArtifacts struct {
+	Attempt 				int 		`json:"attempt"`						// 第几次重试(首次执行为0)
+	FinishTime 				int64 		`json:"finish_time"`					// 完成时间
+	Artifacts 				[]*Artifact `json:"artifacts"`
+}
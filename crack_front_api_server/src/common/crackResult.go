@@ -0,0 +1,60 @@
+package common
+
+// 裂缝识别结果(由worker解析模型程序的输出后写入MongoDB)
+
+type CrackResult struct {
+	TaskType 					string 				`bson:"task_type" json:"task_type"`					// 任务类型(image, video)
+	UserId 						uint 				`bson:"user_id" json:"user_id"`						// 发布该任务的用户id
+	TaskName 					string				`bson:"task_name" json:"task_name"`         		// 任务名称
+	TaskId 						int64 				`bson:"task_id" json:"task_id"`						// 任务id
+
+	ModelVersion 				string 				`bson:"model_version" json:"model_version"`			// 模型版本
+	Detections 					[]*CrackDetection 	`bson:"detections" json:"detections"`				// 图片中识别到的裂缝
+	Frames 						[]*CrackFrame 		`bson:"frames" json:"frames"`						// 视频中每一帧识别到的裂缝
+
+	CrackCount 					int 				`bson:"crack_count" json:"crack_count"`				// 裂缝总数
+	MaxLength 					float64 			`bson:"max_length" json:"max_length"`				// 最长裂缝的长度估计
+	MaxWidth 					float64 			`bson:"max_width" json:"max_width"`					// 最宽裂缝的宽度估计
+	CreateTime 					int64 				`bson:"create_time" json:"create_time"`				// 结果产生时间
+}
+
+// 一条裂缝
+type CrackDetection struct {
+	BBox 						[]float64 			`bson:"bbox" json:"bbox"`							// 外接矩形[x, y, w, h]
+	Polygon 					[][]float64 		`bson:"polygon" json:"polygon"`						// 轮廓多边形[[x, y], ...]
+	Confidence 					float64 			`bson:"confidence" json:"confidence"`				// 置信度[0, 1]
+	Length 						float64 			`bson:"length" json:"length"`						// 长度估计
+	Width 						float64 			`bson:"width" json:"width"`							// 宽度估计
+}
+
+// 视频中的一帧
+type CrackFrame struct {
+	FrameIndex 					int64 				`bson:"frame_index" json:"frame_index"`				// 帧序号
+	Timestamp 					float64 			`bson:"timestamp" json:"timestamp"`					// 该帧在视频中的时间(s)
+	Detections 					[]*CrackDetection 	`bson:"detections" json:"detections"`				// 该帧识别到的裂缝
+}
+
+// 统计裂缝总数和最大长度、宽度
+func (This *CrackResult) Summarize() {
+	var (
+		detection 			*CrackDetection
+		frame 				*CrackFrame
+		detections 			[]*CrackDetection
+	)
+	detections = append(detections, This.Detections...)
+	for _, frame = range This.Frames {
+		detections = append(detections, frame.Detections...)
+	}
+
+	This.CrackCount = len(detections)
+	This.MaxLength = 0
+	This.MaxWidth = 0
+	for _, detection = range detections {
+		if detection.Length > This.MaxLength {
+			This.MaxLength = detection.Length
+		}
+		if detection.Width > This.MaxWidth {
+			This.MaxWidth = detection.Width
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron表达式：分 时 日 月 周，支持 * 、a-b 、*/n 、a-b/n 、逗号分隔的列表，以及@yearly @monthly @weekly @daily @hourly
+// 日和周都不是*时，满足其中一个即可(与crontab一致)
+
+var (
+	ERROR_CRON_EXPR			error = errors.New("cron表达式不合法")
+)
+
+// 最多向后查找的年数，超过时认为表达式永远不会触发(如2月30日)
+const cronMaxYears = 5
+
+type CronExpr struct {
+	minute 					uint64
+	hour 					uint64
+	dom 					uint64
+	month 					uint64
+	dow 					uint64
+	domStar 				bool
+	dowStar 				bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// 解析cron表达式
+func ParseCron(expr string) (cron *CronExpr, err error) {
+	var (
+		fields 				[]string
+		descriptor 			string
+		ok 					bool
+	)
+	expr = strings.TrimSpace(expr)
+	if descriptor, ok = cronDescriptors[expr]; ok {
+		expr = descriptor
+	}
+	if fields = strings.Fields(expr); len(fields) != 5 {
+		return nil, ERROR_CRON_EXPR
+	}
+
+	cron = &CronExpr{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	if cron.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if cron.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if cron.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if cron.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	// 周日可以写成0或7
+	if cron.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if cron.dow & (1 << 7) != 0 {
+		cron.dow |= 1
+	}
+	return cron, nil
+}
+
+// 解析一个字段，返回取值的位图
+func parseCronField(field string, min int, max int) (bits uint64, err error) {
+	var (
+		part 				string
+		rangePart 			string
+		stepPart 			string
+		bounds 				[]string
+		start 				int
+		end 				int
+		step 				int
+		i 					int
+		hasStep 			bool
+	)
+	for _, part = range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep = cutString(part, "/")
+		step = 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, ERROR_CRON_EXPR
+			}
+		}
+
+		if rangePart == "*" {
+			start, end = min, max
+		} else {
+			bounds = strings.SplitN(rangePart, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, ERROR_CRON_EXPR
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, ERROR_CRON_EXPR
+				}
+			} else if hasStep {
+				// a/n 表示从a到最大值每n个
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, ERROR_CRON_EXPR
+		}
+		for i = start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func cutString(s string, sep string) (before string, after string, found bool) {
+	var (
+		index 				int
+	)
+	if index = strings.Index(s, sep); index >= 0 {
+		return s[:index], s[index+len(sep):], true
+	}
+	return s, "", false
+}
+
+// 日期是否匹配
+func (This *CronExpr) matchDay(t time.Time) bool {
+	var (
+		domMatch 			bool
+		dowMatch 			bool
+	)
+	domMatch = This.dom & (1 << uint(t.Day())) != 0
+	dowMatch = This.dow & (1 << uint(t.Weekday())) != 0
+	if This.domStar || This.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// 返回t之后(不含t)的下一个触发时间，永远不会触发时返回零值
+func (This *CronExpr) Next(t time.Time) time.Time {
+	var (
+		limit 				time.Time
+	)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit = t.AddDate(cronMaxYears, 0, 0)
+	for t.Before(limit) {
+		if This.month & (1 << uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month() + 1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !This.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day() + 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if This.hour & (1 << uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour() + 1, 0, 0, 0, t.Location())
+			continue
+		}
+		if This.minute & (1 << uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	var (
+		expr 				string
+		err 				error
+	)
+	for _, expr = range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every",
+	} {
+		if _, err = ParseCron(expr); err != ERROR_CRON_EXPR {
+			t.Errorf("ParseCron(%q) err = %v, want ERROR_CRON_EXPR", expr, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	var (
+		cases 				[]struct {
+			expr 				string
+			from 				string
+			want 				string
+		}
+		index 				int
+		cron 				*CronExpr
+		from 				time.Time
+		want 				time.Time
+		got 				time.Time
+		err 				error
+	)
+	cases = []struct {
+		expr 				string
+		from 				string
+		want 				string
+	}{
+		// 不含起始时间本身，秒被截掉
+		{"* * * * *", "2024-03-10 08:00:00", "2024-03-10 08:01:00"},
+		{"* * * * *", "2024-03-10 08:00:30", "2024-03-10 08:01:00"},
+		// 单个值、列表、范围
+		{"30 9 * * *", "2024-03-10 08:00:00", "2024-03-10 09:30:00"},
+		{"30 9 * * *", "2024-03-10 09:30:00", "2024-03-11 09:30:00"},
+		{"0 8,20 * * *", "2024-03-10 08:00:00", "2024-03-10 20:00:00"},
+		{"0 9-11 * * *", "2024-03-10 11:00:00", "2024-03-11 09:00:00"},
+		// 步长：*/n、a-b/n、a/n
+		{"*/15 * * * *", "2024-03-10 08:16:00", "2024-03-10 08:30:00"},
+		{"*/15 * * * *", "2024-03-10 08:45:00", "2024-03-10 09:00:00"},
+		{"10-40/10 * * * *", "2024-03-10 08:40:00", "2024-03-10 09:10:00"},
+		{"50/5 * * * *", "2024-03-10 08:56:00", "2024-03-10 09:50:00"},
+		// 跨月、跨年
+		{"0 0 1 * *", "2024-01-31 12:00:00", "2024-02-01 00:00:00"},
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+		{"0 0 1 1 *", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// 周：2024-03-10是周日，周日可以写成0或7
+		{"0 12 * * 1", "2024-03-10 00:00:00", "2024-03-11 12:00:00"},
+		{"0 12 * * 0", "2024-03-10 12:00:00", "2024-03-17 12:00:00"},
+		{"0 12 * * 7", "2024-03-10 00:00:00", "2024-03-10 12:00:00"},
+		{"0 0 * * 1-5", "2024-03-08 12:00:00", "2024-03-11 00:00:00"},
+		// 日和周都不是*时满足其中一个即可，有一个是*时两个都要满足
+		{"0 0 15 * 1", "2024-03-10 12:00:00", "2024-03-11 00:00:00"},
+		{"0 0 15 * 1", "2024-03-11 12:00:00", "2024-03-15 00:00:00"},
+		{"0 0 15 * *", "2024-03-10 12:00:00", "2024-03-15 00:00:00"},
+		{"0 0 * 3 1", "2024-03-10 12:00:00", "2024-03-11 00:00:00"},
+		// 描述符
+		{"@hourly", "2024-03-10 08:10:00", "2024-03-10 09:00:00"},
+		{"@daily", "2024-03-10 08:10:00", "2024-03-11 00:00:00"},
+		{"@weekly", "2024-03-10 08:10:00", "2024-03-17 00:00:00"},
+		{"@monthly", "2024-03-10 08:10:00", "2024-04-01 00:00:00"},
+		{"@yearly", "2024-03-10 08:10:00", "2025-01-01 00:00:00"},
+	}
+
+	for index = range cases {
+		if cron, err = ParseCron(cases[index].expr); err != nil {
+			t.Fatalf("ParseCron(%q) err = %v", cases[index].expr, err)
+		}
+		from, _ = time.ParseInLocation("2006-01-02 15:04:05", cases[index].from, time.UTC)
+		want, _ = time.ParseInLocation("2006-01-02 15:04:05", cases[index].want, time.UTC)
+		if got = cron.Next(from); !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", cases[index].expr, cases[index].from, got, want)
+		}
+	}
+}
+
+// 永远不会触发的表达式返回零值
+func TestCronNextNever(t *testing.T) {
+	var (
+		cron 				*CronExpr
+		got 				time.Time
+		err 				error
+	)
+	if cron, err = ParseCron("0 0 30 2 *"); err != nil {
+		t.Fatal(err)
+	}
+	if got = cron.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want zero time", got)
+	}
+}
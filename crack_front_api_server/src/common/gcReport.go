@@ -0,0 +1,20 @@
+package common
+
+// 归档的任务记录(任务的etcd key被回收前写入MongoDB)
+type TaskArchive struct {
+	TaskId 						int64 		`bson:"task_id" json:"task_id"`						// 任务id
+	Status 						*TaskStatus `bson:"status" json:"status"`						// 最终状态
+	Task 						string 		`bson:"task" json:"task"`							// 任务目录中的任务(JSON，由master执行或已被删除的任务为空)
+	ArchiveTime 				int64 		`bson:"archive_time" json:"archive_time"`			// 归档时间
+}
+
+// 垃圾回收报告(Leader每轮回收后更新)
+type GcReport struct {
+	LastRunTime 				int64 		`json:"last_run_time"`				// 最近一轮回收的时间
+	LastTasks 					int 		`json:"last_tasks"`					// 最近一轮归档的任务数
+	LastKeys 					int 		`json:"last_keys"`					// 最近一轮删除的key数
+	LastBytes 					int64 		`json:"last_bytes"`					// 最近一轮回收的字节数(key + value)
+	TotalTasks 					int64 		`json:"total_tasks"`				// 累计归档的任务数
+	TotalKeys 					int64 		`json:"total_keys"`					// 累计删除的key数
+	TotalBytes 					int64 		`json:"total_bytes"`				// 累计回收的字节数
+}
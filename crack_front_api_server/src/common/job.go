@@ -0,0 +1,30 @@
+package common
+
+// 批量任务：一次提交多张图片，每张图片对应一个子任务
+type Job struct {
+	JobId 					int64 		`json:"job_id"`					// 批量任务id
+	JobName 				string 		`json:"job_name"`				// 批量任务名称
+	TaskType 				string 		`json:"task_type"`				// 子任务类型
+	UserId 					uint 		`json:"user_id"`				// 发布该批量任务的用户id
+	TaskTimeOut 			uint 		`json:"task_time_out"`			// 子任务超时时间(s)
+	Priority 				int 		`json:"priority"`				// 子任务优先级
+	Selector 				map[string]string `json:"selector"`			// 子任务的标签选择器
+	MaxRetries 				int 		`json:"max_retries"`			// 子任务最大重试次数
+	RetryBackoff 			int 		`json:"retry_backoff"`			// 子任务首次重试前等待的时间(s)
+	NotBefore 				int64 		`json:"not_before"`				// 子任务最早开始执行的时间(ms)
+	Deadline 				int64 		`json:"deadline"`				// 子任务最晚开始执行的时间(ms)
+	Tasks 					[]*Task 	`json:"tasks"`					// 子任务
+	Cancelled 				bool 		`json:"cancelled"`				// 是否已被取消
+	CreateTime 				int64 		`json:"create_time"`			// 创建时间
+}
+
+// 批量任务的进度
+type JobProgress struct {
+	Job 					*Job 			`json:"job"`
+	Total 					int 			`json:"total"`				// 子任务总数
+	Done 					int 			`json:"done"`				// 成功的子任务数
+	Failed 					int 			`json:"failed"`				// 失败(含被强杀、超时、取消)的子任务数
+	Running 				int 			`json:"running"`			// 正在执行的子任务数
+	Pending 				int 			`json:"pending"`			// 等待执行的子任务数
+	Children 				[]*TaskStatus 	`json:"children"`			// 每个子任务的状态
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// 标签选择器：逗号分隔的key=value，value可以用|列出多个取值(如model=v1|v2)
+// 任务只会被标签匹配的worker执行(worker的标签在其配置中声明，配置了模型程序的任务类型自动作为task_type标签)
+
+var (
+	ERROR_SELECTOR			error = errors.New("标签选择器selector不合法(key=value,key=value)")
+)
+
+// 一个选择器最多的key数
+const MaxSelectorLabels = 8
+
+var (
+	labelKeyRegexp 			= regexp.MustCompile("^[a-z0-9_.-]{1,32}$")
+	labelValueRegexp 		= regexp.MustCompile("^[a-zA-Z0-9_.|-]{1,64}$")
+)
+
+// 解析并校验标签选择器，空字符串表示不限制
+func ParseSelector(s string) (selector map[string]string, err error) {
+	var (
+		pair 				string
+		index 				int
+	)
+	selector = make(map[string]string)
+	for _, pair = range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		if index = strings.Index(pair, "="); index <= 0 {
+			return nil, ERROR_SELECTOR
+		}
+		selector[strings.TrimSpace(pair[:index])] = strings.TrimSpace(pair[index+1:])
+	}
+	if !VerifySelector(selector) {
+		return nil, ERROR_SELECTOR
+	}
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	return selector, nil
+}
+
+func VerifySelector(selector map[string]string) (ok bool) {
+	var (
+		key 				string
+		value 				string
+	)
+	if len(selector) > MaxSelectorLabels {
+		return false
+	}
+	for key, value = range selector {
+		if !labelKeyRegexp.MatchString(key) || !labelValueRegexp.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// 由worker根据配置的模型程序自动生成的标签
+const LabelTaskType = "task_type"
+
+// 任务的标签选择器是否匹配worker的标签
+func MatchSelector(selector map[string]string, labels map[string]string) bool {
+	var (
+		key 				string
+		value 				string
+		label 				string
+		ok 					bool
+	)
+	for key, value = range selector {
+		if label, ok = labels[key]; !ok || !matchLabelValue(value, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// 选择器的取值和标签的取值有一个相同即可
+func matchLabelValue(want string, have string) bool {
+	var (
+		w 					string
+		h 					string
+	)
+	for _, w = range strings.Split(want, "|") {
+		for _, h = range strings.Split(have, "|") {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
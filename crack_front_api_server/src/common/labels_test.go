@@ -0,0 +1,71 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	var (
+		selector 			map[string]string
+		s 					string
+		err 				error
+	)
+	if selector, err = ParseSelector(" gpu = a100 , model=v1|v2,,"); err != nil {
+		t.Fatal(err)
+	}
+	if len(selector) != 2 || selector["gpu"] != "a100" || selector["model"] != "v1|v2" {
+		t.Errorf("selector = %v", selector)
+	}
+
+	// 空字符串表示不限制
+	if selector, err = ParseSelector(""); err != nil || selector != nil {
+		t.Errorf("ParseSelector(\"\") = %v, %v", selector, err)
+	}
+
+	for _, s = range []string{
+		"gpu",
+		"=a100",
+		"gpu=",
+		"GPU=a100",
+		"gpu=a 100",
+		"a=1,b=1,c=1,d=1,e=1,f=1,g=1,h=1,i=1",
+	} {
+		if _, err = ParseSelector(s); err != ERROR_SELECTOR {
+			t.Errorf("ParseSelector(%q) err = %v, want ERROR_SELECTOR", s, err)
+		}
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	var (
+		labels 				map[string]string
+		cases 				[]struct {
+			selector 			map[string]string
+			want 				bool
+		}
+		index 				int
+		got 				bool
+	)
+	labels = map[string]string{LabelTaskType: "image|video", "gpu": "a100", "zone": "bj"}
+	cases = []struct {
+		selector 			map[string]string
+		want 				bool
+	}{
+		{nil, true},
+		{map[string]string{"gpu": "a100"}, true},
+		{map[string]string{"gpu": "a100", "zone": "bj"}, true},
+		{map[string]string{"gpu": "v100"}, false},
+		{map[string]string{"gpu": "v100|a100"}, true},
+		{map[string]string{LabelTaskType: "video"}, true},
+		{map[string]string{LabelTaskType: "audio|video"}, true},
+		{map[string]string{LabelTaskType: "audio"}, false},
+		// worker没有该标签
+		{map[string]string{"rack": "r1"}, false},
+		{map[string]string{"gpu": "a100", "zone": "sh"}, false},
+	}
+	for index = range cases {
+		if got = MatchSelector(cases[index].selector, labels); got != cases[index].want {
+			t.Errorf("MatchSelector(%v) = %v, want %v", cases[index].selector, got, cases[index].want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package common
+
+// 流水线：多个有依赖关系的步骤(如 预处理 --> 识别 --> 测量 --> 报告)组成的有向无环图
+// 每个步骤对应一个任务，上游步骤全部成功后才放入任务目录，上游步骤的输出文件作为该步骤的输入
+type Pipeline struct {
+	PipelineId 				int64 				`json:"pipeline_id"`			// 流水线id
+	PipelineName 			string 				`json:"pipeline_name"`			// 流水线名称
+	UserId 					uint 				`json:"user_id"`				// 发布该流水线的用户id
+	InputRef 				string 				`json:"input_ref"`				// 待识别文件的引用(每个步骤都可以使用)
+	Steps 					[]*PipelineStep 	`json:"steps"`					// 步骤
+	State 					TaskState 			`json:"state"`					// running, succeeded, failed
+	Error 					string 				`json:"error"`					// 失败原因
+	CreateTime 				int64 				`json:"create_time"`			// 创建时间
+	FinishTime 				int64 				`json:"finish_time"`			// 结束时间
+}
+
+// 流水线中的一个步骤
+type PipelineStep struct {
+	Name 					string 				`json:"name"`					// 步骤名称(流水线内唯一)
+	TaskType 				string 				`json:"task_type"`				// 任务类型(决定由哪个模型程序执行)
+	DependsOn 				[]string 			`json:"depends_on"`				// 上游步骤的名称
+	TaskTimeOut 			uint 				`json:"task_time_out"`			// 超时时间(s)
+	Priority 				int 				`json:"priority"`				// 优先级
+	Selector 				map[string]string 	`json:"selector"`				// 标签选择器
+	MaxRetries 				int 				`json:"max_retries"`			// 最大重试次数
+	RetryBackoff 			int 				`json:"retry_backoff"`			// 首次重试前等待的时间(s)
+	TaskId 					int64 				`json:"task_id"`				// 该步骤的任务id(创建流水线时分配)
+	Released 				bool 				`json:"released"`				// 是否已经放入任务目录
+}
+
+// 流水线的进度
+type PipelineProgress struct {
+	Pipeline 				*Pipeline 			`json:"pipeline"`
+	Steps 					[]*TaskStatus 		`json:"steps"`					// 每个步骤的状态(与Pipeline.Steps一一对应)
+}
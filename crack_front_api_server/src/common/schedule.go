@@ -0,0 +1,16 @@
+package common
+
+// 定时任务：按cron表达式周期性地生成任务(如固定摄像头每小时巡检一次)
+type Schedule struct {
+	ScheduleId 				int64 		`json:"schedule_id"`			// 定时任务id
+	ScheduleName 			string 		`json:"schedule_name"`			// 定时任务名称
+	CronExpr 				string 		`json:"cron"`					// cron表达式
+	UserId 					uint 		`json:"user_id"`				// 发布该定时任务的用户id
+	Template 				*Task 		`json:"template"`				// 每次生成的任务的模板
+	Paused 					bool 		`json:"paused"`					// 是否已暂停
+	NextTime 				int64 		`json:"next_time"`				// 下一次计划执行的时间(ms)
+	LastTime 				int64 		`json:"last_time"`				// 上一次计划执行的时间(ms)
+	LastTaskId 				int64 		`json:"last_task_id"`			// 上一次生成的任务id
+	RunCount 				int 		`json:"run_count"`				// 已生成的任务数
+	CreateTime 				int64 		`json:"create_time"`			// 创建时间
+}
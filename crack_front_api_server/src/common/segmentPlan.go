@@ -0,0 +1,9 @@
+package common
+
+// 视频切分计划：一个视频任务按时间切分为多个分段子任务，全部成功后合并结果
+type SegmentPlan struct {
+	Parent 					*Task 		`json:"parent"`					// 被切分的视频任务
+	Duration 				float64 	`json:"duration"`				// 视频时长(s)
+	Fps 					float64 	`json:"fps"`					// 视频帧率
+	Segments 				[]*Task 	`json:"segments"`				// 分段子任务
+}
@@ -1,12 +1,44 @@
 package common
 
-import "regexp"
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
 
 type Task struct {
-	TaskType 				string 		`json:"task_type"`			// 任务类型(image, video)
-	UserId 					uint 		`json:"user_id"`			// 发布该任务的用户id
-	TaskName 				string		`json:"task_name"`         	// 任务名称
-	TaskTimeOut 			uint 		`json:"task_time_out"`		// 任务超时时间(s)
+	TaskType 				string 		`json:"task_type" form:"task_type"`				// 任务类型(image, video)
+	UserId 					uint 		`json:"user_id" form:"-"`						// 发布该任务的用户id
+	TaskName 				string		`json:"task_name" form:"task_name"`         	// 任务名称
+	TaskId 					int64 		`json:"task_id" form:"-"`						// 任务id(由master生成)
+	TaskTimeOut 			uint 		`json:"task_time_out" form:"task_time_out"`		// 任务超时时间(s)
+	InputRef 				string 		`json:"input_ref" form:"-"`						// 待识别文件在存储器中的引用
+	InputRefs 				[]string 	`json:"input_refs" form:"-"`					// 流水线步骤的上游输出文件的引用
+	PipelineId 				int64 		`json:"pipeline_id" form:"-"`					// 所属流水线的id(不属于流水线时为0)
+	JobId 					int64 		`json:"job_id" form:"-"`						// 所属批量任务的id(不属于批量任务时为0)
+	Priority 				int 		`json:"priority" form:"priority"`				// 优先级(0-9)，越大越先执行
+	Selector 				map[string]string `json:"selector" form:"-"`			// 标签选择器，只有标签匹配的worker才执行该任务
+
+	// 失败重试
+	MaxRetries 				int 		`json:"max_retries" form:"max_retries"`			// 最大重试次数，0表示使用任务类型的默认值
+	RetryBackoff 			int 		`json:"retry_backoff" form:"retry_backoff"`		// 首次重试前等待的时间(s)，之后每次翻倍，0表示使用任务类型的默认值
+	Attempt 				int 		`json:"attempt" form:"-"`						// 第几次重试(由worker维护)
+	RetryAt 				int64 		`json:"retry_at" form:"-"`						// 重试的时间(ms，由worker维护)
+
+	// 执行时间窗口
+	NotBefore 				int64 		`json:"not_before" form:"not_before"`			// 最早开始执行的时间(ms)，到达之前任务休眠，0表示不限制
+	Deadline 				int64 		`json:"deadline" form:"deadline"`				// 最晚开始执行的时间(ms)，到达时还没有开始执行的任务过期失败，0表示不限制
+
+	// 定时任务
+	ScheduleId 				int64 		`json:"schedule_id" form:"-"`					// 生成该任务的定时任务id(不是定时生成时为0)
+	ScheduleTime 			int64 		`json:"schedule_time" form:"-"`					// 计划执行的时间(ms)
+
+	// 视频分段
+	ParentId 				int64 		`json:"parent_id" form:"-"`						// 被切分的视频任务id(不是视频分段时为0)
+	SegmentIndex 			int 		`json:"segment_index" form:"-"`					// 分段序号
+	SegmentStart 			float64 	`json:"segment_start" form:"-"`					// 分段在视频中的开始时间(s)
+	SegmentEnd 				float64 	`json:"segment_end" form:"-"`					// 分段在视频中的结束时间(s)，0表示到视频结尾
 }
 
 var (
@@ -14,11 +46,89 @@ var (
 	VideoType				=			"video"
 )
 
+// 各任务类型允许上传的文件后缀
+var (
+	imageExts 				=			[]string{".jpg", ".jpeg", ".png", ".bmp", ".tif", ".tiff"}
+	videoExts 				=			[]string{".mp4", ".avi", ".mov", ".mkv", ".flv"}
+)
+
 func VerifyTaskName(TaskName string) (ok bool){
 	ok, _ = regexp.MatchString("^[a-zA-Z0-9_]{1,16}$", TaskName);
 	return ok
 }
 
+func VerifyScheduleName(ScheduleName string) (ok bool){
+	ok, _ = regexp.MatchString("^[a-zA-Z0-9_]{1,16}$", ScheduleName);
+	return ok
+}
+
+func VerifyPipelineName(PipelineName string) (ok bool){
+	ok, _ = regexp.MatchString("^[a-zA-Z0-9_]{1,16}$", PipelineName);
+	return ok
+}
+
+// 流水线步骤可以是任意worker配置了模型程序的任务类型
+func VerifyStepType(TaskType string) (ok bool){
+	ok, _ = regexp.MatchString("^[a-z0-9_]{1,16}$", TaskType);
+	return ok
+}
+
+func VerifyJobName(JobName string) (ok bool){
+	ok, _ = regexp.MatchString("^[a-zA-Z0-9_]{1,16}$", JobName);
+	return ok
+}
+
+// 任务优先级的取值范围
+const MaxPriority = 9
+
+func VerifyTaskPriority(Priority int) (ok bool){
+	return Priority >= 0 && Priority <= MaxPriority
+}
+
+// 任务最多重试的次数
+const MaxTaskRetries = 10
+
+func VerifyTaskRetry(MaxRetries int, RetryBackoff int) (ok bool){
+	return MaxRetries >= 0 && MaxRetries <= MaxTaskRetries && RetryBackoff >= 0
+}
+
+// 最晚开始时间必须在未来，并且晚于最早开始时间
+func VerifyTaskWindow(NotBefore int64, Deadline int64) (ok bool){
+	if NotBefore < 0 || Deadline < 0 {
+		return false
+	}
+	if Deadline == 0 {
+		return true
+	}
+	return Deadline > time.Now().UnixNano() / 1000 / 1000 && Deadline > NotBefore
+}
+
 func VerifyTaskType(TaskType string) (ok bool){
 	return TaskType == ImageType || TaskType == VideoType
-}
\ No newline at end of file
+}
+
+func VerifyTaskFile(TaskType string, FileName string) (ok bool){
+	var (
+		ext 					string
+		exts 					[]string
+		allowed 				string
+	)
+	switch TaskType {
+	case ImageType:
+		exts = imageExts
+	case VideoType:
+		exts = videoExts
+	}
+	ext = strings.ToLower(path.Ext(FileName))
+	for _, allowed = range exts {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// 任务在now(ms)时是否已经过期：过了最晚开始时间还没有开始执行
+func (This *Task) Expired(now int64) bool {
+	return This.Deadline > 0 && This.Deadline <= now
+}
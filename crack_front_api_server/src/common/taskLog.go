@@ -4,9 +4,17 @@ type TaskLog struct {
 	TaskType 					string 		`bson:"task_type" json:"task_type"`							// 任务类型(image, video)
 	UserId 						uint 		`bson:"user_id" json:"user_id"`								// 发布该任务的用户id
 	TaskName 					string		`bson:"task_name" json:"task_name"`         				// 任务名称
+	TaskId 						int64 		`bson:"task_id" json:"task_id"`								// 任务id
+	Attempt 					int 		`bson:"attempt" json:"attempt"`								// 第几次重试(首次执行为0)
 
-	TaskOutput					string		`bson:"task_output" json:"task_output"`						// 任务标准输出
-	TaskError					string		`bson:"task_error" json:"task_error"`						// 任务错误输出
+	TaskStdout					string		`bson:"task_stdout" json:"task_stdout"`						// 模型程序的标准输出
+	TaskStderr					string		`bson:"task_stderr" json:"task_stderr"`						// 模型程序的标准错误
+	ExitCode					int			`bson:"exit_code" json:"exit_code"`							// 模型程序的退出码(-1表示没有启动或者被信号终止)
+	Signal						string		`bson:"signal" json:"signal"`								// 终止模型程序的信号(如SIGKILL)
+	KillSignal					string		`bson:"kill_signal" json:"kill_signal"`						// 强杀或超时时worker向进程组最后发送的信号(SIGTERM表示在宽限期内退出，SIGKILL表示被强制终止)
+	TaskError					string		`bson:"task_error" json:"task_error"`						// 执行失败的原因
+	ErrorClass					string		`bson:"error_class" json:"error_class"`						// 错误类别(exit, timeout, result, input, output, expired, oom, pids)
+	Artifacts					[]*Artifact	`bson:"artifacts" json:"artifacts"`							// 本次执行保存的产物文件
 	ScheduleTime				int64		`bson:"schedule_time" json:"schedule_time"`					// 理论被调度的时间
 	RealScheduleTime			int64		`bson:"real_schedule_time" json:"real_schedule_time"`		// 真正被调度的时间
 	ExecTime					int64		`bson:"exec_time" json:"exec_time"`							// 执行时间
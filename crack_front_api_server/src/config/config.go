@@ -28,6 +28,16 @@ type Config struct {
 	WarnDir				string
 	FinishDir			string
 	FailDir				string
+	TaskIdKey			string
+	StatusDir			string
+	JobDir				string
+	SegmentDir			string
+	ScheduleDir			string
+	PipelineDir			string
+	AssignDir			string
+	StreamDir			string
+	IdempotencyDir		string
+	IdempotencyRetention	time.Duration
 
 	// worker
 	WorkersDir			string
@@ -43,6 +53,45 @@ type Config struct {
 
 	// MySQL
 	MySQL_DataSourceName 		string
+
+	// blob
+	BlobBackend 				string
+	BlobLocalRoot 				string
+	MaxUploadSize 				int64
+
+	// ftp
+	FtpAddr 					string
+	FtpUser 					string
+	FtpPassword 				string
+	FtpDir 						string
+	FtpTimeout 					time.Duration
+
+	// video
+	SegmentLength 				float64
+	FfprobePath 				string
+	ProbeTimeout 				time.Duration
+	MergeInterval 				time.Duration
+	MergeTimeout 				time.Duration
+
+	// schedule
+	ScheduleInterval 			time.Duration
+
+	// pipeline
+	PipelineInterval 			time.Duration
+	MaxPipelineSteps 			int
+
+	// assign
+	AssignEnabled 				bool
+	AssignInterval 				time.Duration
+
+	// gc
+	GcInterval 					time.Duration
+	GcRetention 				time.Duration
+	GcBatchSize 				int
+	GcReportKey 				string
+
+	// stream
+	StreamHeartbeat 			time.Duration
 }
 
 // 配置的单例
@@ -97,6 +146,38 @@ func InitConfig(configFile string) error {
 			return err
 		}
 
+		if err = initBlobConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initFtpConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initVideoConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initScheduleConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initPipelineConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initAssignConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initGcConfig(cf, &config); err != nil{
+			return err
+		}
+
+		if err = initStreamConfig(cf, &config); err != nil{
+			return err
+		}
+
 		Cfg = &config
 	}
 	return nil
@@ -175,6 +256,17 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 		warnDir				string
 		finishDir			string
 		failDir				string
+		taskIdKey			string
+		statusDir			string
+		jobDir				string
+		segmentDir			string
+		scheduleDir			string
+		pipelineDir			string
+		assignDir			string
+		idempotencyDir		string
+		retentionStr		string
+		retention			int
+		streamDir			string
 	)
 
 	if taskDir, err = cf.GetValue("task", "TaskDir"); err != nil{
@@ -192,12 +284,55 @@ func initTaskConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	if failDir, err = cf.GetValue("task", "FailDir"); err != nil{
 		return err
 	}
+	if taskIdKey, err = cf.GetValue("task", "TaskIdKey"); err != nil{
+		return err
+	}
+	if statusDir, err = cf.GetValue("task", "StatusDir"); err != nil{
+		return err
+	}
+	if jobDir, err = cf.GetValue("task", "JobDir"); err != nil{
+		return err
+	}
+	if segmentDir, err = cf.GetValue("task", "SegmentDir"); err != nil{
+		return err
+	}
+	if scheduleDir, err = cf.GetValue("task", "ScheduleDir"); err != nil{
+		return err
+	}
+	if pipelineDir, err = cf.GetValue("task", "PipelineDir"); err != nil{
+		return err
+	}
+	if assignDir, err = cf.GetValue("task", "AssignDir"); err != nil{
+		return err
+	}
+	if streamDir, err = cf.GetValue("task", "StreamDir"); err != nil{
+		return err
+	}
+	if idempotencyDir, err = cf.GetValue("task", "IdempotencyDir"); err != nil{
+		return err
+	}
+	if retentionStr, err = cf.GetValue("task", "IdempotencyRetention"); err != nil{
+		return err
+	}
+	if retention, err = strconv.Atoi(retentionStr); err != nil{
+		return err
+	}
 
 	config.TaskDir = taskDir
 	config.KillerDir = killerDir
 	config.WarnDir = warnDir
 	config.FinishDir = finishDir
 	config.FailDir = failDir
+	config.TaskIdKey = taskIdKey
+	config.StatusDir = statusDir
+	config.JobDir = jobDir
+	config.SegmentDir = segmentDir
+	config.ScheduleDir = scheduleDir
+	config.PipelineDir = pipelineDir
+	config.AssignDir = assignDir
+	config.StreamDir = streamDir
+	config.IdempotencyDir = idempotencyDir
+	config.IdempotencyRetention = time.Duration(retention)*time.Second
 
 	return nil
 }
@@ -316,4 +451,269 @@ func initMySQLConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
 	config.MySQL_DataSourceName = user + ":" + password + "@(" + ip + ":" + port + ")/" + dbName + "?charset=utf8mb4&parseTime=True&loc=Local&timeout=" + connectTimeOut + "ms"
 
 	return nil
-}
\ No newline at end of file
+}
+
+// 初始blob存储配置
+func initBlobConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		backend 				string
+		localRoot 				string
+		maxUploadSizeStr		string
+		maxUploadSize			int
+	)
+
+	if backend, err = cf.GetValue("blob", "Backend"); err != nil{
+		return err
+	}
+	if localRoot, err = cf.GetValue("blob", "LocalRoot"); err != nil{
+		return err
+	}
+	if maxUploadSizeStr, err = cf.GetValue("blob", "MaxUploadSize"); err != nil{
+		return err
+	}
+
+	if maxUploadSize, err = strconv.Atoi(maxUploadSizeStr); err != nil{
+		return err
+	}
+
+	config.BlobBackend = strings.ToLower(backend)
+	config.BlobLocalRoot = localRoot
+	config.MaxUploadSize = int64(maxUploadSize) << 20
+
+	return nil
+}
+
+// 初始ftp配置
+func initFtpConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		ip 						string
+		port 					string
+		user 					string
+		password 				string
+		dir 					string
+		timeoutStr				string
+		timeout					int
+	)
+
+	if ip, err = cf.GetValue("ftp", "Ip"); err != nil{
+		return err
+	}
+	if port, err = cf.GetValue("ftp", "Port"); err != nil{
+		return err
+	}
+	if user, err = cf.GetValue("ftp", "User"); err != nil{
+		return err
+	}
+	if password, err = cf.GetValue("ftp", "Password"); err != nil{
+		return err
+	}
+	if dir, err = cf.GetValue("ftp", "Dir"); err != nil{
+		return err
+	}
+	if timeoutStr, err = cf.GetValue("ftp", "Timeout"); err != nil{
+		return err
+	}
+
+	if _, err = strconv.Atoi(port); err != nil {
+		return err
+	}
+	if timeout, err = strconv.Atoi(timeoutStr); err != nil{
+		return err
+	}
+
+	config.FtpAddr = ip + ":" + port
+	config.FtpUser = user
+	config.FtpPassword = password
+	config.FtpDir = dir
+	config.FtpTimeout = time.Duration(timeout)*time.Millisecond
+
+	return nil
+}
+
+// 初始视频切分配置
+func initVideoConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		segmentLengthStr		string
+		segmentLength			int
+		ffprobePath				string
+		probeTimeoutStr			string
+		probeTimeout			int
+		mergeIntervalStr		string
+		mergeInterval			int
+		mergeTimeoutStr			string
+		mergeTimeout			int
+	)
+
+	if segmentLengthStr, err = cf.GetValue("video", "SegmentLength"); err != nil{
+		return err
+	}
+	if ffprobePath, err = cf.GetValue("video", "FfprobePath"); err != nil{
+		return err
+	}
+	if probeTimeoutStr, err = cf.GetValue("video", "ProbeTimeout"); err != nil{
+		return err
+	}
+	if mergeIntervalStr, err = cf.GetValue("video", "MergeInterval"); err != nil{
+		return err
+	}
+	if mergeTimeoutStr, err = cf.GetValue("video", "MergeTimeout"); err != nil{
+		return err
+	}
+
+	if segmentLength, err = strconv.Atoi(segmentLengthStr); err != nil{
+		return err
+	}
+	if probeTimeout, err = strconv.Atoi(probeTimeoutStr); err != nil{
+		return err
+	}
+	if mergeInterval, err = strconv.Atoi(mergeIntervalStr); err != nil{
+		return err
+	}
+	if mergeTimeout, err = strconv.Atoi(mergeTimeoutStr); err != nil{
+		return err
+	}
+
+	config.SegmentLength = float64(segmentLength)
+	config.FfprobePath = ffprobePath
+	config.ProbeTimeout = time.Duration(probeTimeout)*time.Millisecond
+	config.MergeInterval = time.Duration(mergeInterval)*time.Millisecond
+	config.MergeTimeout = time.Duration(mergeTimeout)*time.Millisecond
+
+	return nil
+}
+
+// 初始定时任务配置
+func initScheduleConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		intervalStr				string
+		interval				int
+	)
+
+	if intervalStr, err = cf.GetValue("schedule", "Interval"); err != nil{
+		return err
+	}
+	if interval, err = strconv.Atoi(intervalStr); err != nil{
+		return err
+	}
+
+	config.ScheduleInterval = time.Duration(interval)*time.Millisecond
+
+	return nil
+}
+
+// 初始流水线配置
+func initPipelineConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		intervalStr				string
+		interval				int
+		maxStepsStr				string
+		maxSteps				int
+	)
+
+	if intervalStr, err = cf.GetValue("pipeline", "Interval"); err != nil{
+		return err
+	}
+	if maxStepsStr, err = cf.GetValue("pipeline", "MaxSteps"); err != nil{
+		return err
+	}
+	if interval, err = strconv.Atoi(intervalStr); err != nil{
+		return err
+	}
+	if maxSteps, err = strconv.Atoi(maxStepsStr); err != nil{
+		return err
+	}
+
+	config.PipelineInterval = time.Duration(interval)*time.Millisecond
+	config.MaxPipelineSteps = maxSteps
+
+	return nil
+}
+
+// 初始任务分配配置
+func initAssignConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		enabledStr				string
+		enabled					bool
+		intervalStr				string
+		interval				int
+	)
+
+	if enabledStr, err = cf.GetValue("assign", "Enabled"); err != nil{
+		return err
+	}
+	if intervalStr, err = cf.GetValue("assign", "Interval"); err != nil{
+		return err
+	}
+	if enabled, err = strconv.ParseBool(enabledStr); err != nil{
+		return err
+	}
+	if interval, err = strconv.Atoi(intervalStr); err != nil{
+		return err
+	}
+
+	config.AssignEnabled = enabled
+	config.AssignInterval = time.Duration(interval)*time.Millisecond
+
+	return nil
+}
+
+// 初始垃圾回收配置
+func initGcConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		intervalStr				string
+		interval				int
+		retentionStr			string
+		retention				int
+		batchSizeStr			string
+		batchSize				int
+		reportKey				string
+	)
+
+	if intervalStr, err = cf.GetValue("gc", "Interval"); err != nil{
+		return err
+	}
+	if retentionStr, err = cf.GetValue("gc", "Retention"); err != nil{
+		return err
+	}
+	if batchSizeStr, err = cf.GetValue("gc", "BatchSize"); err != nil{
+		return err
+	}
+	if reportKey, err = cf.GetValue("gc", "ReportKey"); err != nil{
+		return err
+	}
+	if interval, err = strconv.Atoi(intervalStr); err != nil{
+		return err
+	}
+	if retention, err = strconv.Atoi(retentionStr); err != nil{
+		return err
+	}
+	if batchSize, err = strconv.Atoi(batchSizeStr); err != nil{
+		return err
+	}
+
+	config.GcInterval = time.Duration(interval)*time.Millisecond
+	config.GcRetention = time.Duration(retention)*time.Second
+	config.GcBatchSize = batchSize
+	config.GcReportKey = reportKey
+
+	return nil
+}
+
+// 初始化实时输出配置
+func initStreamConfig(cf *goconfig.ConfigFile, config *Config) (err error) {
+	var(
+		heartbeatStr			string
+		heartbeat				int
+	)
+
+	if heartbeatStr, err = cf.GetValue("stream", "Heartbeat"); err != nil{
+		return err
+	}
+	if heartbeat, err = strconv.Atoi(heartbeatStr); err != nil{
+		return err
+	}
+
+	config.StreamHeartbeat = time.Duration(heartbeat)*time.Millisecond
+
+	return nil
+}
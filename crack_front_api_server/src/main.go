@@ -3,10 +3,17 @@ package main
 import (
 	"crack_front/src/config"
 	"crack_front/src/master/alerter"
+	"crack_front/src/master/assigner"
+	"crack_front/src/master/blobStore"
 	"crack_front/src/master/elector"
+	"crack_front/src/master/garbageCollector"
+	"crack_front/src/master/jobManager"
 	"crack_front/src/master/logManager"
 	"crack_front/src/master/logger"
+	"crack_front/src/master/pipelineManager"
 	"crack_front/src/master/router"
+	"crack_front/src/master/scheduleManager"
+	"crack_front/src/master/segmenter"
 	"crack_front/src/master/taskManager"
 	"crack_front/src/master/user"
 	"crack_front/src/master/workerManager"
@@ -67,6 +74,14 @@ func main()  {
 	defer user.CloseMySQL()
 	logger.Logger.InfoLog("crack_front连接MySQL成功")
 
+	// 初始化文件存储器
+	if err = blobStore.InitBlobStore(); err != nil{
+		fmt.Println("crack_front初始化文件存储器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化文件存储器成功")
+
 	// 初始化任务管理器
 	if err = taskManager.InitTaskManager(); err != nil{
 		fmt.Println("crack_front初始化任务管理器错误:", err)
@@ -75,6 +90,54 @@ func main()  {
 	}
 	logger.Logger.InfoLog("crack_front初始化任务管理器成功")
 
+	// 初始化批量任务管理器
+	if err = jobManager.InitJobManager(); err != nil{
+		fmt.Println("crack_front初始化批量任务管理器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化批量任务管理器成功")
+
+	// 初始化视频切分器
+	if err = segmenter.InitSegmenter(); err != nil{
+		fmt.Println("crack_front初始化视频切分器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化视频切分器成功")
+
+	// 初始化定时任务管理器
+	if err = scheduleManager.InitScheduleManager(); err != nil{
+		fmt.Println("crack_front初始化定时任务管理器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化定时任务管理器成功")
+
+	// 初始化流水线管理器
+	if err = pipelineManager.InitPipelineManager(); err != nil{
+		fmt.Println("crack_front初始化流水线管理器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化流水线管理器成功")
+
+	// 初始化垃圾回收器
+	if err = garbageCollector.InitGarbageCollector(); err != nil{
+		fmt.Println("crack_front初始化垃圾回收器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化垃圾回收器成功")
+
+	// 初始化任务分配器
+	if err = assigner.InitAssigner(); err != nil{
+		fmt.Println("crack_front初始化任务分配器错误:", err)
+		logger.Logger.WarnLog(err)
+		return
+	}
+	logger.Logger.InfoLog("crack_front初始化任务分配器成功")
+
 	// 初始化任务执行日志管理器
 	if err = logManager.InitLogManager(); err != nil{
 		fmt.Println("crack_front初始化任务管执行日志管理器错误:", err)
@@ -0,0 +1,282 @@
+package assigner
+
+import (
+	"bytes"
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logManager"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"crack_front/src/master/workerManager"
+	"encoding/json"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strings"
+	"time"
+)
+
+// 任务分配器。Leader把任务目录中等待执行的任务分配给某个worker：AssignDir/worker的IP/任务类型/用户id/任务名
+// 每个worker只监听自己的分配目录，不再所有worker抢同一个任务的锁
+// 按worker发布的标签和空闲槽位选择worker，worker从WorkersDir消失时把分配给它的任务重新分配
+
+type Assigner struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 一个分配记录
+type assignment struct {
+	workerIP 			string
+	kvPair 				*mvccpb.KeyValue
+}
+
+// 分配记录的key
+func assignKey(workerIP string, userTask string) string {
+	return path.Join(config.Cfg.AssignDir, workerIP, userTask)
+}
+
+// 读取所有分配记录，类型/用户id/任务名 --> 分配记录
+func (This *Assigner) listAssignments() (assignments map[string]*assignment, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		rel 				string
+		index 				int
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.AssignDir, clientv3.WithPrefix()); err != nil {
+		return
+	}
+	assignments = make(map[string]*assignment, len(getResp.Kvs))
+	for _, kvPair = range getResp.Kvs {
+		rel = strings.TrimPrefix(string(kvPair.Key), config.Cfg.AssignDir)
+		if index = strings.Index(rel, "/"); index <= 0 {
+			continue
+		}
+		assignments[rel[index+1:]] = &assignment{
+			workerIP: rel[:index],
+			kvPair:   kvPair,
+		}
+	}
+	return assignments, nil
+}
+
+// 选择worker：标签匹配任务的选择器，并且该任务类型的空闲槽位减去已分配还没开始执行的任务数最大
+// 没有worker还有空闲槽位时不分配，任务留在任务目录中等下一轮
+func (This *Assigner) choose(task *common.Task, workers []*common.WorkerCapacity, load map[string]int) (workerIP string) {
+	var (
+		worker 				*common.WorkerCapacity
+		typeCapacity 		*common.TypeCapacity
+		ok 					bool
+		score 				int
+		bestScore 			int
+	)
+	for _, worker = range workers {
+		if typeCapacity, ok = worker.Types[task.TaskType]; !ok || !common.MatchSelector(task.Selector, worker.Labels) {
+			continue
+		}
+		if score = typeCapacity.Free - load[worker.Ip]; score <= 0 {
+			continue
+		}
+		if workerIP == "" || score > bestScore {
+			workerIP, bestScore = worker.Ip, score
+		}
+	}
+	return
+}
+
+// 删除分配记录(只删除读出时的版本)
+func (This *Assigner) unassign(assigned *assignment) (err error) {
+	_, err = This.kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.ModRevision(string(assigned.kvPair.Key)), "=", assigned.kvPair.ModRevision)).Then(
+		clientv3.OpDelete(string(assigned.kvPair.Key))).Commit()
+	return
+}
+
+// 分配任务，任务在此期间被修改或删除时放弃，下一轮再分配
+func (This *Assigner) assign(workerIP string, userTask string, taskKv *mvccpb.KeyValue) (err error) {
+	_, err = This.kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.ModRevision(string(taskKv.Key)), "=", taskKv.ModRevision)).Then(
+		clientv3.OpPut(assignKey(workerIP, userTask), string(taskKv.Value))).Commit()
+	return
+}
+
+// 已下线的worker抢占或正在执行的任务回到pending，重新分配
+func (This *Assigner) requeue(status *common.TaskStatus, workerIP string) (err error) {
+	if (status.State != common.TaskClaimed && status.State != common.TaskRunning) || status.Worker != workerIP {
+		return nil
+	}
+	if status, err = taskManager.TM.TransitionTaskStatus(status.TaskId, common.TaskPending, "worker " + workerIP + "下线，重新分配"); err != nil {
+		return
+	}
+	_ = logManager.LM.SaveTaskStatus(status)
+	logger.Logger.InfoLog("worker下线，重新分配任务:", status.TaskName, "task_id=", status.TaskId)
+	return nil
+}
+
+// 一轮分配
+func (This *Assigner) assignAll() (err error) {
+	var (
+		workers 			[]*common.WorkerCapacity
+		worker 				*common.WorkerCapacity
+		alive 				map[string]bool
+		assignments 		map[string]*assignment
+		assigned 			*assignment
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		tasks 				map[string]*common.Task
+		task 				*common.Task
+		taskIds 			[]int64
+		statuses 			map[int64]*common.TaskStatus
+		status 				*common.TaskStatus
+		load 				map[string]int
+		userTask 			string
+		workerIP 			string
+	)
+	if workers, err = workerManager.WM.GetWorkerCapacities(); err != nil {
+		return
+	}
+	alive = make(map[string]bool, len(workers))
+	for _, worker = range workers {
+		alive[worker.Ip] = true
+	}
+	if assignments, err = This.listAssignments(); err != nil {
+		return
+	}
+
+	// 任务目录中的任务及其状态
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.TaskDir, clientv3.WithPrefix()); err != nil {
+		return
+	}
+	tasks = make(map[string]*common.Task, len(getResp.Kvs))
+	for _, kvPair = range getResp.Kvs {
+		task = &common.Task{}
+		if err = json.Unmarshal(kvPair.Value, task); err != nil {
+			logger.Logger.InfoLog("任务反序列化错误...已丢弃该错误:", err.Error())
+			continue
+		}
+		tasks[strings.TrimPrefix(string(kvPair.Key), config.Cfg.TaskDir)] = task
+		taskIds = append(taskIds, task.TaskId)
+	}
+	if statuses, err = taskManager.TM.GetTaskStatuses(taskIds); err != nil {
+		return
+	}
+
+	// 每个worker已分配但还没开始执行的任务数
+	load = make(map[string]int, len(workers))
+	for userTask, assigned = range assignments {
+		if task = tasks[userTask]; task != nil && statuses[task.TaskId] != nil && statuses[task.TaskId].State == common.TaskPending {
+			load[assigned.workerIP]++
+		}
+	}
+
+	for _, kvPair = range getResp.Kvs {
+		userTask = strings.TrimPrefix(string(kvPair.Key), config.Cfg.TaskDir)
+		if task = tasks[userTask]; task == nil {
+			continue
+		}
+		status = statuses[task.TaskId]
+		assigned = assignments[userTask]
+		delete(assignments, userTask)
+
+		// 已经结束的任务不再需要分配记录
+		if status == nil || status.State.IsFinal() {
+			if assigned != nil {
+				err = This.unassign(assigned)
+			}
+			continue
+		}
+
+		if assigned != nil {
+			// 任务被修改(如重试时重新写入)，把新的任务内容同步给worker
+			if alive[assigned.workerIP] {
+				if !bytes.Equal(assigned.kvPair.Value, kvPair.Value) {
+					err = This.assign(assigned.workerIP, userTask, kvPair)
+				}
+				continue
+			}
+			// worker已经下线
+			if err = This.unassign(assigned); err != nil {
+				continue
+			}
+			if err = This.requeue(status, assigned.workerIP); err != nil {
+				logger.Logger.WarnLog("重新分配任务失败, task_id=", task.TaskId, "err=", err)
+				continue
+			}
+		} else if status.State != common.TaskPending {
+			continue
+		}
+
+		if workerIP = This.choose(task, workers, load); workerIP == "" {
+			continue
+		}
+		if err = This.assign(workerIP, userTask, kvPair); err != nil {
+			continue
+		}
+		load[workerIP]++
+	}
+
+	// 任务已经被删除的分配记录
+	for _, assigned = range assignments {
+		err = This.unassign(assigned)
+	}
+	return err
+}
+
+// 成为Leader后启动，ctx被取消(失去Leader)时退出。没有开启分配时不启动
+func (This *Assigner) Start(ctx context.Context) {
+	var (
+		ticker 				*time.Ticker
+		err 				error
+	)
+	if !config.Cfg.AssignEnabled {
+		return
+	}
+	ticker = time.NewTicker(config.Cfg.AssignInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err = This.assignAll(); err != nil {
+				logger.Logger.WarnLog("分配任务失败:", err)
+			}
+		}
+	}
+}
+
+// 任务分配器单例
+var (
+	AS 					*Assigner
+)
+
+func InitAssigner() (err error) {
+	if AS == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		AS = &Assigner{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
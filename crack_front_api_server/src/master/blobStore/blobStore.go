@@ -0,0 +1,88 @@
+package blobStore
+
+import (
+	"crack_front/src/config"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 上传文件的存储器。master将用户上传的图片/视频存入存储器，任务中只保存文件的引用(scheme://name)
+// worker根据引用从同一个存储后端中取出文件
+
+type BlobStore interface {
+	// 保存文件，返回文件的引用
+	Put(name string, reader io.Reader) (ref string, err error)
+	// 把引用对应的文件写入writer(如下载worker保存的产物文件)
+	Get(ref string, writer io.Writer) (err error)
+}
+
+// 存储后端
+const (
+	LocalBackend			=			"local"
+	FtpBackend				=			"ftp"
+)
+
+var (
+	ERROR_BLOB_BACKEND		error = errors.New("不支持的文件存储后端")
+	ERROR_BLOB_REF			error = errors.New("非法的文件引用")
+)
+
+// 生成文件引用
+func newRef(backend string, name string) string {
+	return backend + "://" + strings.TrimPrefix(name, "/")
+}
+
+// 解析文件引用
+func parseRef(ref string) (backend string, name string, err error) {
+	var (
+		index 			int
+	)
+	if index = strings.Index(ref, "://"); index <= 0 {
+		return "", "", ERROR_BLOB_REF
+	}
+	backend = ref[:index]
+	name = ref[index+3:]
+	// 不允许跳出存储根目录
+	if len(name) == 0 || strings.Contains(name, "..") {
+		return "", "", ERROR_BLOB_REF
+	}
+	return
+}
+
+// 用户直接给出的文件引用(如摄像头定时上传的截图)是否属于当前的存储后端，并且是该用户上传的该类型的文件
+// 上传的文件名都以 任务类型/用户id/ 开头，不允许引用其他用户的文件
+func ValidRef(ref string, taskType string, userId uint) bool {
+	var (
+		backend 		string
+		name 			string
+		prefix 			string
+		err 			error
+	)
+	if backend, name, err = parseRef(ref); err != nil {
+		return false
+	}
+	prefix = taskType + "/" + strconv.Itoa(int(userId)) + "/"
+	return backend == config.Cfg.BlobBackend && strings.HasPrefix(name, prefix) && len(name) > len(prefix)
+}
+
+// 存储器单例
+var (
+	Store			BlobStore
+)
+
+// 初始化存储器
+func InitBlobStore() (err error) {
+	if Store == nil {
+		switch config.Cfg.BlobBackend {
+		case LocalBackend:
+			Store, err = newLocalStore(config.Cfg.BlobLocalRoot)
+		case FtpBackend:
+			Store, err = newFtpStore(config.Cfg.FtpAddr, config.Cfg.FtpUser, config.Cfg.FtpPassword, config.Cfg.FtpDir, config.Cfg.FtpTimeout)
+		default:
+			err = ERROR_BLOB_BACKEND
+		}
+	}
+	return
+}
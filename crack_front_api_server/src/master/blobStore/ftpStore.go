@@ -0,0 +1,83 @@
+package blobStore
+
+import (
+	"io"
+	"path"
+	"time"
+)
+
+// ftp服务器存储，每次操作建立一个新连接
+type ftpStore struct {
+	addr 				string
+	user 				string
+	password 			string
+	dir 				string
+	timeout 			time.Duration
+}
+
+func newFtpStore(addr string, user string, password string, dir string, timeout time.Duration) (store *ftpStore, err error) {
+	var (
+		conn 				*ftpConn
+	)
+	// 检查ftp服务器是否可用
+	if conn, err = dialFtp(addr, user, password, timeout); err != nil {
+		return
+	}
+	conn.quit()
+
+	store = &ftpStore{
+		addr:     addr,
+		user:     user,
+		password: password,
+		dir:      dir,
+		timeout:  timeout,
+	}
+	return
+}
+
+func (This *ftpStore) Put(name string, reader io.Reader) (ref string, err error) {
+	var (
+		conn 				*ftpConn
+		filePath 			string
+	)
+	ref = newRef(FtpBackend, name)
+	if _, name, err = parseRef(ref); err != nil {
+		return "", err
+	}
+	filePath = path.Join(This.dir, name)
+
+	if conn, err = dialFtp(This.addr, This.user, This.password, This.timeout); err != nil {
+		return "", err
+	}
+	defer conn.quit()
+
+	conn.mkdirAll(path.Dir(filePath))
+	// 先上传临时文件再改名，防止worker读到写了一半的文件
+	if err = conn.stor(filePath+".uploading", reader); err != nil {
+		return "", err
+	}
+	if err = conn.rename(filePath+".uploading", filePath); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+func (This *ftpStore) Get(ref string, writer io.Writer) (err error) {
+	var (
+		conn 				*ftpConn
+		backend 			string
+		name 				string
+	)
+	if backend, name, err = parseRef(ref); err != nil {
+		return
+	}
+	if backend != FtpBackend {
+		return ERROR_BLOB_REF
+	}
+
+	if conn, err = dialFtp(This.addr, This.user, This.password, This.timeout); err != nil {
+		return
+	}
+	defer conn.quit()
+	return conn.retr(path.Join(This.dir, name), writer)
+}
@@ -0,0 +1,79 @@
+package blobStore
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// 本地文件系统存储(多台机器时需要把根目录挂载为共享目录)
+type localStore struct {
+	root 				string
+}
+
+func newLocalStore(root string) (store *localStore, err error) {
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return
+	}
+	store = &localStore{
+		root: root,
+	}
+	return
+}
+
+func (This *localStore) Put(name string, reader io.Reader) (ref string, err error) {
+	var (
+		filePath 			string
+		tmpPath 			string
+		fp 					*os.File
+	)
+	ref = newRef(LocalBackend, name)
+	if _, name, err = parseRef(ref); err != nil {
+		return "", err
+	}
+	filePath = filepath.Join(This.root, filepath.FromSlash(path.Clean(name)))
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+
+	// 先写临时文件再改名，防止worker读到写了一半的文件
+	tmpPath = filePath + ".uploading"
+	if fp, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(fp, reader); err != nil {
+		_ = fp.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err = fp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return ref, nil
+}
+
+func (This *localStore) Get(ref string, writer io.Writer) (err error) {
+	var (
+		backend 			string
+		name 				string
+		fp 					*os.File
+	)
+	if backend, name, err = parseRef(ref); err != nil {
+		return
+	}
+	if backend != LocalBackend {
+		return ERROR_BLOB_REF
+	}
+	if fp, err = os.Open(filepath.Join(This.root, filepath.FromSlash(path.Clean(name)))); err != nil {
+		return
+	}
+	defer fp.Close()
+	_, err = io.Copy(writer, fp)
+	return
+}
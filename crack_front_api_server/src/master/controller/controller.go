@@ -2,16 +2,31 @@ package controller
 
 import (
 	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/blobStore"
+	"crack_front/src/master/garbageCollector"
+	"crack_front/src/master/jobManager"
 	"crack_front/src/master/logManager"
+	"crack_front/src/master/logger"
 	"crack_front/src/master/middleware"
+	"crack_front/src/master/pipelineManager"
+	"crack_front/src/master/scheduleManager"
+	"crack_front/src/master/segmenter"
 	"crack_front/src/master/taskManager"
 	"crack_front/src/master/user"
 	"crack_front/src/master/workerManager"
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Content-Type: application/json
@@ -202,17 +217,24 @@ func Login(c *gin.Context) {
 
 
 // POST 用户发起识别请求
+// Content-Type: multipart/form-data  字段:task_type task_name task_time_out priority max_retries retry_backoff not_before deadline selector file(待识别的图片或视频)
+// 可选请求头Idempotency-Key：保留期内带同一个key的重复提交(如网络错误后重试)只对应一个任务，返回第一次提交的任务id
 func CrackIdentify(c *gin.Context)  {
 	var(
 		err     		error
 		task    		= &common.Task{}
 		userId			interface{}
 		ok				bool
-		finishChan		<-chan struct{}
-		failChan		<-chan struct{}
-		errChan			<-chan error
+		fileHeader		*multipart.FileHeader
+		file			multipart.File
+		blobName		string
+		duration		float64
+		fps				float64
+		idempotencyKey	string
+		firstTaskId		int64
+		submitted		bool
 	)
-	if err = c.BindJSON(task); err != nil{
+	if err = c.ShouldBind(task); err != nil{
 		c.JSON(http.StatusCreated, gin.H{
 			"errno":1,
 			"message":err.Error(),
@@ -236,6 +258,38 @@ func CrackIdentify(c *gin.Context)  {
 		return
 	}
 
+	if !common.VerifyTaskPriority(task.Priority) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "priority不合法(0-9)",
+		})
+		return
+	}
+
+	if task.Selector, err = common.ParseSelector(c.PostForm("selector")); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if !common.VerifyTaskRetry(task.MaxRetries, task.RetryBackoff) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "max_retries(0-10)或retry_backoff不合法",
+		})
+		return
+	}
+
+	if !common.VerifyTaskWindow(task.NotBefore, task.Deadline) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "not_before或deadline不合法(deadline必须在未来且晚于not_before)",
+		})
+		return
+	}
+
 	if userId, ok = c.Get("UserId"); !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"errno": 1,
@@ -246,53 +300,140 @@ func CrackIdentify(c *gin.Context)  {
 	}
 	task.UserId = userId.(uint)
 
-	// 插入任务
-	if err = taskManager.TM.SaveTask(task); err != nil {
-		c.JSON(http.StatusAccepted, gin.H{
+	// 待识别的文件
+	if fileHeader, err = c.FormFile("file"); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
 			"errno":1,
-			"message":err.Error(),
-			"data": nil,
+			"message": "缺少待识别的文件file",
+		})
+		return
+	}
+
+	if !common.VerifyTaskFile(task.TaskType, fileHeader.Filename) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "文件格式与task_type不匹配",
+		})
+		return
+	}
+
+	if fileHeader.Size > config.Cfg.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"errno":1,
+			"message": "上传的文件过大",
 		})
+		return
+	}
+
+	// 先占用Idempotency-Key再保存文件，重复提交不会再上传文件和写入任务
+	if idempotencyKey = c.GetHeader("Idempotency-Key"); idempotencyKey != "" {
+		if len(idempotencyKey) > 255 {
+			c.JSON(http.StatusCreated, gin.H{
+				"errno":1,
+				"message": "Idempotency-Key过长(最多255个字符)",
+			})
+			return
+		}
+		if task.TaskId, err = taskManager.TM.NewTaskId(); err == nil {
+			firstTaskId, err = taskManager.TM.ReserveIdempotencyKey(task.UserId, idempotencyKey, task.TaskId)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+		if firstTaskId != 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"errno": 0,
+				"message": "该Idempotency-Key已经提交过任务",
+				"task_id": firstTaskId,
+			})
+			return
+		}
+		// 之后提交失败时释放，客户端可以用同一个key重试
+		defer func() {
+			if !submitted {
+				taskManager.TM.ReleaseIdempotencyKey(task.UserId, idempotencyKey)
+			}
+		}()
 	}
 
-	// 等待任务完成
-	finishChan, failChan, errChan = taskManager.TM.WatchTask(task)
-	select {
-	case err = <-errChan:
-		// 通知错误
+	// 保存文件到存储器，任务中只携带文件引用
+	if file, err = fileHeader.Open(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errno":1,
 			"message":err.Error(),
 		})
-	case <-failChan:
-		// 通知识别出错
+		return
+	}
+
+	// 获取视频时长，判断是否需要切分。获取失败时不切分，整个视频由一个worker执行
+	if task.TaskType == "video" && config.Cfg.SegmentLength > 0 {
+		if duration, fps, err = segmenter.ProbeVideo(file); err != nil {
+			logger.Logger.WarnLog("获取视频时长失败, 不切分:", err)
+			duration = 0
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			_ = file.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+	}
+
+	blobName = path.Join(task.TaskType, strconv.Itoa(int(task.UserId)), task.TaskName, strconv.FormatInt(time.Now().UnixNano(), 10) + strings.ToLower(path.Ext(fileHeader.Filename)))
+	task.InputRef, err = blobStore.Store.Put(blobName, file)
+	_ = file.Close()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errno":1,
 			"message":err.Error(),
 		})
-	case <-finishChan:
-		// 通知识别完成
-		c.JSON(http.StatusOK, gin.H{
-			"errno": 0,
-			"message": "识别完成",
+		return
+	}
+
+	// 插入任务，不等待任务执行完毕，客户端通过任务id查询状态。较长的视频切分为多个分段由不同worker执行
+	if segmenter.NeedSplit(duration, fps) {
+		err = segmenter.VS.SplitTask(task, duration, fps)
+	} else {
+		err = taskManager.TM.SaveTask(task)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data": nil,
 		})
+		return
 	}
-}
+	submitted = true
 
+	c.JSON(http.StatusAccepted, gin.H{
+		"errno": 0,
+		"message": "任务已提交",
+		"task_id": task.TaskId,
+	})
+}
 
-// DELETE 从etcd中删除任务
-func RemoveTask(c *gin.Context)  {
+// GET 获取任务状态
+func GetTaskStatus(c *gin.Context)  {
 	var (
-		ok					bool
-		err 				error
-		task 				*common.Task
-		userId				interface{}
+		ok 				bool
+		err 			error
+		userId			interface{}
+		taskId			int64
+		status			*common.TaskStatus
 	)
 
-	if err = c.BindJSON(&task); err != nil{
+	if taskId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
 		c.JSON(http.StatusCreated, gin.H{
 			"errno":1,
-			"message":err.Error(),
+			"message":"任务id不合法",
+			"data":nil,
 		})
 		return
 	}
@@ -301,33 +442,97 @@ func RemoveTask(c *gin.Context)  {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"errno": 1,
 			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
 		})
 		return
 	}
-	task.UserId = userId.(uint)
 
-	if _, err = taskManager.TM.RemoveTask(task); err != nil{
+	// 先查etcd中的权威记录，etcd中没有时(master重启、记录已被清理)再查MongoDB中的镜像
+	if status, err = taskManager.TM.GetTaskStatus(taskId); err == taskManager.ERROR_TASK_NOT_FOUND {
+		if status, err = logManager.LM.QueryTaskStatus(taskId); err == mongo.ErrNoDocuments {
+			err = taskManager.ERROR_TASK_NOT_FOUND
+		}
+	}
+
+	// 只能查询自己的任务
+	if err == taskManager.ERROR_TASK_NOT_FOUND || (err == nil && status.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"任务不存在",
+			"data":nil,
+		})
+	}else if err != nil{
 		c.JSON(http.StatusAccepted, gin.H{
 			"errno":1,
-			"message":"success",
+			"message":err.Error(),
+			"data":nil,
 		})
 	}else{
 		c.JSON(http.StatusOK, gin.H{
 			"errno":0,
 			"message":"success",
+			"data":status,
 		})
 	}
 }
 
-
-// GET 获取etcd当前有哪些任务
-func GetTasks(c *gin.Context)  {
+// POST 取消还没有被worker抢占的任务，任务状态置为cancelled。已经开始执行的任务需要强杀
+func CancelTask(c *gin.Context)  {
 	var (
+		ok 				bool
 		err 			error
-		taskList 		[]*common.Task
+		userId			interface{}
+		taskId			int64
+		status			*common.TaskStatus
+		found			bool
 	)
 
-	if taskList, err = taskManager.TM.GetTaskList(); err != nil{
+	if taskId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"任务id不合法",
+			"data":nil,
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+
+	// 只能取消自己的任务
+	if status, err = taskManager.TM.GetTaskStatus(taskId); err == taskManager.ERROR_TASK_NOT_FOUND || (err == nil && status.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"任务不存在",
+			"data":nil,
+		})
+		return
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	// 切分执行的视频任务由master停止它的所有分段，其他任务取消还没有被抢占的任务
+	if status, found, err = segmenter.VS.CancelTask(taskId); !found && err == nil{
+		status, err = taskManager.TM.CancelTask(taskId)
+	}
+	if err == taskManager.ERROR_TASK_STARTED{
+		c.JSON(http.StatusConflict, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":status,
+		})
+	}else if err != nil{
 		c.JSON(http.StatusAccepted, gin.H{
 			"errno":1,
 			"message":err.Error(),
@@ -336,25 +541,27 @@ func GetTasks(c *gin.Context)  {
 	}else{
 		c.JSON(http.StatusOK, gin.H{
 			"errno":0,
-			"message":"success",
-			"data":taskList,
+			"message":"任务已取消",
+			"data":status,
 		})
 	}
 }
 
-// POST 强制杀死任务进程
-func KillTask(c *gin.Context)  {
+// GET 获取任务的识别结果
+func GetTaskResult(c *gin.Context)  {
 	var (
-		ok					bool
-		err 				error
-		task				*common.Task
-		userId				interface{}
+		ok 				bool
+		err 			error
+		userId			interface{}
+		taskId			int64
+		result			*common.CrackResult
 	)
 
-	if err = c.BindJSON(&task); err != nil{
+	if taskId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
 		c.JSON(http.StatusCreated, gin.H{
 			"errno":1,
-			"message":err.Error(),
+			"message":"任务id不合法",
+			"data":nil,
 		})
 		return
 	}
@@ -363,72 +570,96 @@ func KillTask(c *gin.Context)  {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"errno": 1,
 			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
 		})
 		return
 	}
-	task.UserId = userId.(uint)
 
-	if err = taskManager.TM.KillTask(task); err != nil{
+	if result, err = logManager.LM.QueryTaskResult(taskId, userId.(uint)); err == mongo.ErrNoDocuments{
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"该任务没有识别结果",
+			"data":nil,
+		})
+	}else if err != nil{
 		c.JSON(http.StatusAccepted, gin.H{
 			"errno":1,
 			"message":err.Error(),
+			"data":nil,
 		})
 	}else{
 		c.JSON(http.StatusOK, gin.H{
 			"errno":0,
 			"message":"success",
+			"data":result,
 		})
 	}
 }
 
-// GET 获取任务执行日志
-func QueryTaskLog(c *gin.Context) {
-	var(
+// GET 任务的产物文件。没有name参数时列出每次执行保存的产物文件，
+// 有name参数时下载该文件(attempt参数指定第几次执行，默认为最后一次保存了该文件的执行)
+func GetTaskArtifacts(c *gin.Context)  {
+	var (
 		ok 				bool
 		err 			error
-		taskName		string
-		skipStr			string
-		skip 			int
-		limitStr		string
-		limit 			int
-		taskLogList		[]*common.TaskLog
+		userId			interface{}
+		taskId			int64
+		status			*common.TaskStatus
+		taskArtifacts	[]*common.TaskArtifacts
+		name 			string
+		attempt 		int
+		index 			int
+		artifact 		*common.Artifact
+		found 			*common.Artifact
 	)
-	if taskName, ok = c.GetQuery("task_name"); !ok{
+
+	if taskId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
 		c.JSON(http.StatusCreated, gin.H{
 			"errno":1,
-			"message":"缺少query字段:task_name",
+			"message":"任务id不合法",
 			"data":nil,
 		})
 		return
 	}
-	if skipStr, ok = c.GetQuery("skip"); !ok{
-		c.JSON(http.StatusCreated, gin.H{
-			"errno":1,
-			"message":"缺少query字段:skip",
-			"data":nil,
-		})
-		return
+	attempt = -1
+	if c.Query("attempt") != "" {
+		if attempt, err = strconv.Atoi(c.Query("attempt")); err != nil || attempt < 0 {
+			c.JSON(http.StatusCreated, gin.H{
+				"errno":1,
+				"message":"attempt不合法",
+				"data":nil,
+			})
+			return
+		}
 	}
-	if limitStr, ok = c.GetQuery("limit"); !ok{
-		c.JSON(http.StatusCreated, gin.H{
-			"errno":1,
-			"message":"缺少query字段:limit",
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
 			"data":nil,
 		})
 		return
 	}
 
-	if skip, err = strconv.Atoi(skipStr); err != nil{
-		c.JSON(http.StatusCreated, gin.H{
+	// 只能查询自己的任务
+	if status, err = taskManager.TM.GetTaskStatus(taskId); err == taskManager.ERROR_TASK_NOT_FOUND {
+		if status, err = logManager.LM.QueryTaskStatus(taskId); err == mongo.ErrNoDocuments {
+			err = taskManager.ERROR_TASK_NOT_FOUND
+		}
+	}
+	if err == nil {
+		taskArtifacts, err = logManager.LM.QueryTaskArtifacts(taskId)
+	}
+	if err == taskManager.ERROR_TASK_NOT_FOUND || (err == nil && status.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
 			"errno":1,
-			"message": err.Error(),
+			"message":"任务不存在",
 			"data":nil,
 		})
 		return
-	}
-
-	if limit, err = strconv.Atoi(limitStr); err != nil{
-		c.JSON(http.StatusCreated, gin.H{
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
 			"errno":1,
 			"message":err.Error(),
 			"data":nil,
@@ -436,39 +667,1186 @@ func QueryTaskLog(c *gin.Context) {
 		return
 	}
 
-	if taskLogList, err = logManager.LM.QueryTaskLog(taskName, int64(skip), int64(limit)); err != nil{
-		c.JSON(http.StatusAccepted, gin.H{
-			"errno":1,
-			"message":err.Error(),
-			"data":nil,
-		})
-	}else{
+	if name = c.Query("name"); name == "" {
 		c.JSON(http.StatusOK, gin.H{
 			"errno":0,
 			"message":"success",
-			"data":taskLogList,
+			"data":taskArtifacts,
+		})
+		return
+	}
+
+	// 从最后一次执行往前找
+	for index = len(taskArtifacts) - 1; index >= 0 && found == nil; index-- {
+		if attempt >= 0 && taskArtifacts[index].Attempt != attempt {
+			continue
+		}
+		for _, artifact = range taskArtifacts[index].Artifacts {
+			if artifact.Name == name {
+				found = artifact
+				break
+			}
+		}
+	}
+	if found == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"产物文件不存在",
+			"data":nil,
 		})
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", "attachment; filename=\"" + path.Base(found.Name) + "\"")
+	c.Header("Content-Length", strconv.FormatInt(found.Size, 10))
+	if err = blobStore.Store.Get(found.Ref, c.Writer); err != nil {
+		logger.Logger.WarnLog("下载产物文件失败, task_id=", taskId, "name=", name, "err=", err)
+		// 还没有开始写入时返回错误信息，否则只能中断下载
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.Header("Content-Disposition", "")
+			c.Header("Content-Length", "")
+			c.JSON(http.StatusAccepted, gin.H{
+				"errno":1,
+				"message":err.Error(),
+				"data":nil,
+			})
+		}
 	}
 }
 
-// GET 获取健康的worker节点
-func GetWorkers(c *gin.Context)  {
+// 已经推送到的位置(第几次执行的第几行)
+type streamCursor struct {
+	attempt 			int
+	seq 				int64
+}
+
+// 推送stream中cursor之后的行
+func sendStreamLines(c *gin.Context, stream *common.TaskStream, cursor *streamCursor) {
+	var (
+		line 				*common.StreamLine
+	)
+	if stream == nil {
+		return
+	}
+	if stream.Attempt != cursor.attempt {
+		cursor.attempt = stream.Attempt
+		cursor.seq = 0
+		c.SSEvent("attempt", gin.H{"attempt": stream.Attempt, "worker": stream.Worker})
+	}
+	for _, line = range stream.Lines {
+		if line.Seq <= cursor.seq {
+			continue
+		}
+		if line.Seq > cursor.seq + 1 {
+			c.SSEvent("gap", gin.H{"from": cursor.seq + 1, "to": line.Seq - 1})
+		}
+		c.SSEvent("output", line)
+		cursor.seq = line.Seq
+	}
+	c.Writer.Flush()
+}
+
+// GET 跟踪任务执行时的标准输出和标准错误(Server-Sent Events)，任务结束时推送最终状态并结束
+// event: attempt 新的一次执行开始(重试)，data为{"attempt","worker"}
+// event: output 一行输出，data为{"seq","stream","text","time"}
+// event: gap 已经被worker丢弃、没有推送的行，data为{"from","to"}
+// event: progress 执行进度更新，data为{"progress","stage","metrics","update_time"}
+// event: state 任务结束，data为任务状态，之后连接关闭
+// event: heartbeat 没有新输出时定期发送
+func StreamTask(c *gin.Context)  {
 	var (
+		ok 				bool
 		err 			error
-		workers			[]string
+		userId			interface{}
+		taskId			int64
+		status			*common.TaskStatus
+		stream			*common.TaskStream
+		revision		int64
+		cursor 			streamCursor
+		streamChan		chan *common.TaskStream
+		statusChan		chan *common.TaskStatus
+		heartbeat		*time.Ticker
 	)
 
-	if workers, err = workerManager.WM.GetWorkers(); err != nil{
-		c.JSON(http.StatusAccepted, gin.H{
-			"errno": 1,
-			"message": err.Error(),
-			"data": nil,
+	if taskId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"任务id不合法",
+			"data":nil,
 		})
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"errno": 0,
-			"message": "success",
-			"data": workers,
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+
+	// etcd中的状态已经被清理的任务直接从MongoDB中读取最终状态
+	if stream, status, revision, err = taskManager.TM.GetTaskStream(taskId); err == nil && status == nil {
+		if status, err = logManager.LM.QueryTaskStatus(taskId); err == mongo.ErrNoDocuments {
+			err = taskManager.ERROR_TASK_NOT_FOUND
+		}
+	}
+
+	// 只能跟踪自己的任务
+	if err == taskManager.ERROR_TASK_NOT_FOUND || (err == nil && status.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"任务不存在",
+			"data":nil,
+		})
+		return
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	sendStreamLines(c, stream, &cursor)
+	if status.State.IsFinal() {
+		c.SSEvent("state", status)
+		c.Writer.Flush()
+		return
+	}
+	if status.Progress != nil {
+		c.SSEvent("progress", status.Progress)
+		c.Writer.Flush()
+	}
+
+	streamChan, statusChan = taskManager.TM.WatchTaskStream(c.Request.Context(), taskId, revision)
+	heartbeat = time.NewTicker(config.Cfg.StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case stream, ok = <-streamChan:
+			if !ok {
+				return
+			}
+			sendStreamLines(c, stream, &cursor)
+		case status, ok = <-statusChan:
+			if !ok {
+				return
+			}
+			// 执行中的状态变化只推送进度
+			if !status.State.IsFinal() {
+				if status.Progress != nil {
+					c.SSEvent("progress", status.Progress)
+					c.Writer.Flush()
+				}
+				continue
+			}
+			// 结束前再读一次，推送最后的输出
+			if stream, _, _, err = taskManager.TM.GetTaskStream(taskId); err == nil {
+				sendStreamLines(c, stream, &cursor)
+			}
+			c.SSEvent("state", status)
+			c.Writer.Flush()
+			return
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", time.Now().UnixNano() / 1000 / 1000)
+			c.Writer.Flush()
+		}
+	}
+}
+
+// DELETE 从etcd中删除任务
+func RemoveTask(c *gin.Context)  {
+	var (
+		ok					bool
+		err 				error
+		task 				*common.Task
+		userId				interface{}
+	)
+
+	if err = c.BindJSON(&task); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+		})
+		return
+	}
+	task.UserId = userId.(uint)
+
+	if _, err = taskManager.TM.RemoveTask(task); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":"success",
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+		})
+	}
+}
+
+
+// GET 获取etcd当前有哪些任务
+func GetTasks(c *gin.Context)  {
+	var (
+		err 			error
+		taskList 		[]*common.Task
+		statuses 		[]*common.TaskStatus
+		items 			[]*common.TaskListItem
+		index 			int
+	)
+
+	if taskList, err = taskManager.TM.GetTaskList(); err == nil{
+		statuses, err = taskManager.TM.LookupTaskStatuses(taskList)
+	}
+	if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	// 附上每个任务的状态和执行进度
+	items = make([]*common.TaskListItem, 0, len(taskList))
+	for index = range taskList {
+		items = append(items, &common.TaskListItem{
+			Task:     taskList[index],
+			State:    statuses[index].State,
+			Progress: statuses[index].Progress,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"errno":0,
+		"message":"success",
+		"data":items,
+	})
+}
+
+// POST 强制杀死任务进程
+func KillTask(c *gin.Context)  {
+	var (
+		ok					bool
+		err 				error
+		task				*common.Task
+		userId				interface{}
+		found				bool
+	)
+
+	if err = c.BindJSON(&task); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+		})
+		return
+	}
+	task.UserId = userId.(uint)
+
+	// 切分执行的视频任务不在任务目录中，由master停止它的所有分段
+	if found, err = segmenter.VS.KillTask(task); !found && err == nil{
+		err = taskManager.TM.KillTask(task)
+	}
+	if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+		})
+	}
+}
+
+// GET 获取任务执行日志
+func QueryTaskLog(c *gin.Context) {
+	var(
+		ok 				bool
+		err 			error
+		taskName		string
+		skipStr			string
+		skip 			int
+		limitStr		string
+		limit 			int
+		taskLogList		[]*common.TaskLog
+	)
+	if taskName, ok = c.GetQuery("task_name"); !ok{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"缺少query字段:task_name",
+			"data":nil,
+		})
+		return
+	}
+	if skipStr, ok = c.GetQuery("skip"); !ok{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"缺少query字段:skip",
+			"data":nil,
+		})
+		return
+	}
+	if limitStr, ok = c.GetQuery("limit"); !ok{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"缺少query字段:limit",
+			"data":nil,
+		})
+		return
+	}
+
+	if skip, err = strconv.Atoi(skipStr); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	if limit, err = strconv.Atoi(limitStr); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	if taskLogList, err = logManager.LM.QueryTaskLog(taskName, int64(skip), int64(limit)); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":taskLogList,
+		})
+	}
+}
+
+// GET 获取健康的worker节点
+func GetWorkers(c *gin.Context)  {
+	var (
+		err 			error
+		workers			[]string
+	)
+
+	if workers, err = workerManager.WM.GetWorkers(); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno": 1,
+			"message": err.Error(),
+			"data": nil,
+		})
+	} else {
+		c.JSON(http.StatusOK, gin.H{
+			"errno": 0,
+			"message": "success",
+			"data": workers,
+		})
+	}
+}
+
+// GET 获取所有worker的执行容量(并发上限、正在执行的任务数、空闲槽位)
+func GetWorkerCapacities(c *gin.Context)  {
+	var (
+		err 			error
+		capacities		[]*common.WorkerCapacity
+	)
+
+	if capacities, err = workerManager.WM.GetWorkerCapacities(); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno": 1,
+			"message": err.Error(),
+			"data": nil,
+		})
+	} else {
+		c.JSON(http.StatusOK, gin.H{
+			"errno": 0,
+			"message": "success",
+			"data": capacities,
+		})
+	}
+}
+
+// POST 提交批量任务
+// Content-Type: multipart/form-data  字段:job_name task_type task_time_out priority max_retries retry_backoff not_before deadline selector files(多个待识别文件)
+func SubmitJob(c *gin.Context)  {
+	var(
+		err     		error
+		ok				bool
+		userId			interface{}
+		job				= &common.Job{}
+		taskTimeOut		int
+		form			*multipart.Form
+		fileHeaders		[]*multipart.FileHeader
+		fileHeader		*multipart.FileHeader
+		file			multipart.File
+		index			int
+		blobName		string
+		inputRef		string
+		inputRefs		[]string
+	)
+	job.JobName = c.PostForm("job_name")
+	job.TaskType = c.PostForm("task_type")
+
+	if !common.VerifyTaskType(job.TaskType) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "请使用正确的task_type['image', 'video']",
+		})
+		return
+	}
+
+	if !common.VerifyJobName(job.JobName) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "批量任务名称job_name不合法",
+		})
+		return
+	}
+
+	if taskTimeOut, err = strconv.Atoi(c.DefaultPostForm("task_time_out", "0")); err != nil || taskTimeOut < 0 {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "task_time_out不合法",
+		})
+		return
+	}
+	job.TaskTimeOut = uint(taskTimeOut)
+
+	if job.Priority, err = strconv.Atoi(c.DefaultPostForm("priority", "0")); err != nil || !common.VerifyTaskPriority(job.Priority) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "priority不合法(0-9)",
+		})
+		return
+	}
+
+	if job.Selector, err = common.ParseSelector(c.PostForm("selector")); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if job.MaxRetries, err = strconv.Atoi(c.DefaultPostForm("max_retries", "0")); err == nil {
+		job.RetryBackoff, err = strconv.Atoi(c.DefaultPostForm("retry_backoff", "0"))
+	}
+	if err != nil || !common.VerifyTaskRetry(job.MaxRetries, job.RetryBackoff) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "max_retries(0-10)或retry_backoff不合法",
+		})
+		return
+	}
+
+	if job.NotBefore, err = strconv.ParseInt(c.DefaultPostForm("not_before", "0"), 10, 64); err == nil {
+		job.Deadline, err = strconv.ParseInt(c.DefaultPostForm("deadline", "0"), 10, 64)
+	}
+	if err != nil || !common.VerifyTaskWindow(job.NotBefore, job.Deadline) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "not_before或deadline不合法(deadline必须在未来且晚于not_before)",
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+	job.UserId = userId.(uint)
+
+	// 待识别的文件
+	if form, err = c.MultipartForm(); err != nil || len(form.File["files"]) == 0 {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "缺少待识别的文件files",
+		})
+		return
+	}
+	fileHeaders = form.File["files"]
+
+	// 先校验所有文件，避免保存了一部分文件后才发现非法文件
+	for _, fileHeader = range fileHeaders {
+		if !common.VerifyTaskFile(job.TaskType, fileHeader.Filename) {
+			c.JSON(http.StatusCreated, gin.H{
+				"errno":1,
+				"message": "文件格式与task_type不匹配:" + fileHeader.Filename,
+			})
+			return
+		}
+		if fileHeader.Size > config.Cfg.MaxUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"errno":1,
+				"message": "上传的文件过大:" + fileHeader.Filename,
+			})
+			return
+		}
+	}
+
+	// 保存文件到存储器
+	inputRefs = make([]string, 0, len(fileHeaders))
+	for index, fileHeader = range fileHeaders {
+		if file, err = fileHeader.Open(); err != nil {
+			break
+		}
+		blobName = path.Join(job.TaskType, strconv.Itoa(int(job.UserId)), job.JobName, strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + strconv.Itoa(index) + strings.ToLower(path.Ext(fileHeader.Filename)))
+		inputRef, err = blobStore.Store.Put(blobName, file)
+		_ = file.Close()
+		if err != nil {
+			break
+		}
+		inputRefs = append(inputRefs, inputRef)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	// 展开为子任务
+	if err = jobManager.JM.SaveJob(job, inputRefs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"errno": 0,
+		"message": "批量任务已提交",
+		"job_id": job.JobId,
+		"total": len(job.Tasks),
+	})
+}
+
+// 从路由参数中取得批量任务，并校验是否属于当前用户
+func getUserJob(c *gin.Context) (job *common.Job, ok bool) {
+	var (
+		err 			error
+		userId			interface{}
+		jobId			int64
+	)
+
+	if jobId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"批量任务id不合法",
+			"data":nil,
+		})
+		return nil, false
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return nil, false
+	}
+
+	if job, err = jobManager.JM.GetJob(jobId); err == jobManager.ERROR_JOB_NOT_FOUND || (err == nil && job.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"批量任务不存在",
+			"data":nil,
+		})
+		return nil, false
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return nil, false
+	}
+	return job, true
+}
+
+// GET 获取批量任务的进度
+func GetJob(c *gin.Context)  {
+	var (
+		ok 				bool
+		err 			error
+		job				*common.Job
+		progress		*common.JobProgress
+	)
+
+	if job, ok = getUserJob(c); !ok {
+		return
+	}
+
+	if progress, err = jobManager.JM.GetJobProgress(job); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":progress,
+		})
+	}
+}
+
+// POST 取消批量任务，强杀所有还没有结束的子任务
+func CancelJob(c *gin.Context)  {
+	var (
+		ok 				bool
+		err 			error
+		job				*common.Job
+	)
+
+	if job, ok = getUserJob(c); !ok {
+		return
+	}
+
+	if err = jobManager.JM.CancelJob(job); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+		})
+	}
+}
+
+// POST 创建定时任务
+// Content-Type: multipart/form-data  字段:schedule_name cron task_type task_time_out priority max_retries retry_backoff selector
+// file(待识别的文件) 或 input_ref(存储器中已有文件的引用，如摄像头定时覆盖上传的截图)
+func CreateSchedule(c *gin.Context)  {
+	var(
+		err     		error
+		ok				bool
+		userId			interface{}
+		schedule		= &common.Schedule{}
+		task			= &common.Task{}
+		fileHeader		*multipart.FileHeader
+		file			multipart.File
+		blobName		string
+	)
+	if err = c.ShouldBind(task); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+	schedule.ScheduleName = c.PostForm("schedule_name")
+	schedule.CronExpr = c.PostForm("cron")
+
+	if !common.VerifyScheduleName(schedule.ScheduleName) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "定时任务名称schedule_name不合法",
+		})
+		return
+	}
+
+	if _, err = common.ParseCron(schedule.CronExpr); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if !common.VerifyTaskType(task.TaskType) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "请使用正确的task_type['image', 'video']",
+		})
+		return
+	}
+
+	if !common.VerifyTaskPriority(task.Priority) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "priority不合法(0-9)",
+		})
+		return
+	}
+
+	if task.Selector, err = common.ParseSelector(c.PostForm("selector")); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if !common.VerifyTaskRetry(task.MaxRetries, task.RetryBackoff) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "max_retries(0-10)或retry_backoff不合法",
+		})
+		return
+	}
+
+	// 定时任务每次运行的时间由cron决定，不能指定固定的执行时间窗口
+	if task.NotBefore != 0 || task.Deadline != 0 {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "定时任务不支持not_before和deadline",
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+	schedule.UserId = userId.(uint)
+	task.UserId = schedule.UserId
+
+	// 待识别的文件：上传文件或者直接给出存储器中的引用
+	if fileHeader, err = c.FormFile("file"); err == nil {
+		if !common.VerifyTaskFile(task.TaskType, fileHeader.Filename) {
+			c.JSON(http.StatusCreated, gin.H{
+				"errno":1,
+				"message": "文件格式与task_type不匹配",
+			})
+			return
+		}
+		if fileHeader.Size > config.Cfg.MaxUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"errno":1,
+				"message": "上传的文件过大",
+			})
+			return
+		}
+		if file, err = fileHeader.Open(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+		blobName = path.Join(task.TaskType, strconv.Itoa(int(task.UserId)), schedule.ScheduleName, strconv.FormatInt(time.Now().UnixNano(), 10) + strings.ToLower(path.Ext(fileHeader.Filename)))
+		task.InputRef, err = blobStore.Store.Put(blobName, file)
+		_ = file.Close()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+	} else if task.InputRef = c.PostForm("input_ref"); !blobStore.ValidRef(task.InputRef, task.TaskType, task.UserId) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "缺少待识别的文件file或input_ref不合法",
+		})
+		return
+	}
+	schedule.Template = task
+
+	if err = scheduleManager.SM.SaveSchedule(schedule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"errno": 0,
+		"message": "定时任务已创建",
+		"data": schedule,
+	})
+}
+
+// GET 获取当前用户的所有定时任务
+func GetSchedules(c *gin.Context)  {
+	var (
+		err 			error
+		ok				bool
+		userId			interface{}
+		schedules		[]*common.Schedule
+	)
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+
+	if schedules, err = scheduleManager.SM.GetUserSchedules(userId.(uint)); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":schedules,
+		})
+	}
+}
+
+// 从路由参数中取得定时任务，并校验是否属于当前用户
+func getUserSchedule(c *gin.Context) (schedule *common.Schedule, ok bool) {
+	var (
+		err 			error
+		userId			interface{}
+		scheduleId		int64
+	)
+
+	if scheduleId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"定时任务id不合法",
+			"data":nil,
+		})
+		return nil, false
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return nil, false
+	}
+
+	if schedule, err = scheduleManager.SM.GetSchedule(scheduleId); err == scheduleManager.ERROR_SCHEDULE_NOT_FOUND || (err == nil && schedule.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"定时任务不存在",
+			"data":nil,
+		})
+		return nil, false
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return nil, false
+	}
+	return schedule, true
+}
+
+// GET 获取定时任务
+func GetSchedule(c *gin.Context)  {
+	var (
+		ok 				bool
+		schedule		*common.Schedule
+	)
+
+	if schedule, ok = getUserSchedule(c); !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"errno":0,
+		"message":"success",
+		"data":schedule,
+	})
+}
+
+// POST 暂停定时任务
+func PauseSchedule(c *gin.Context)  {
+	var (
+		ok 				bool
+		err 			error
+		schedule		*common.Schedule
+	)
+
+	if schedule, ok = getUserSchedule(c); !ok {
+		return
+	}
+
+	if schedule, err = scheduleManager.SM.PauseSchedule(schedule.ScheduleId); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":schedule,
+		})
+	}
+}
+
+// POST 恢复定时任务，暂停期间错过的运行不再补上
+func ResumeSchedule(c *gin.Context)  {
+	var (
+		ok 				bool
+		err 			error
+		schedule		*common.Schedule
+	)
+
+	if schedule, ok = getUserSchedule(c); !ok {
+		return
+	}
+
+	if schedule, err = scheduleManager.SM.ResumeSchedule(schedule.ScheduleId); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":schedule,
+		})
+	}
+}
+
+// 流水线直接给出的文件引用：该用户为流水线上传的文件，或者为某个步骤的任务类型上传的文件
+func validPipelineRef(pipeline *common.Pipeline) bool {
+	var (
+		step 			*common.PipelineStep
+	)
+	if blobStore.ValidRef(pipeline.InputRef, "pipeline", pipeline.UserId) {
+		return true
+	}
+	for _, step = range pipeline.Steps {
+		if blobStore.ValidRef(pipeline.InputRef, step.TaskType, pipeline.UserId) {
+			return true
+		}
+	}
+	return false
+}
+
+// POST 提交流水线
+// Content-Type: multipart/form-data  字段:pipeline_name steps(步骤的JSON数组) file(待识别的文件) 或 input_ref
+// 步骤字段:name task_type depends_on task_time_out priority max_retries retry_backoff selector(key到取值的对象)
+func SubmitPipeline(c *gin.Context)  {
+	var(
+		err     		error
+		ok				bool
+		userId			interface{}
+		pipeline		= &common.Pipeline{}
+		fileHeader		*multipart.FileHeader
+		file			multipart.File
+		blobName		string
+	)
+	pipeline.PipelineName = c.PostForm("pipeline_name")
+	if !common.VerifyPipelineName(pipeline.PipelineName) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "流水线名称pipeline_name不合法",
+		})
+		return
+	}
+
+	if err = json.Unmarshal([]byte(c.PostForm("steps")), &pipeline.Steps); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "steps不合法:" + err.Error(),
+		})
+		return
+	}
+	if err = pipelineManager.VerifySteps(pipeline.Steps); err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+	pipeline.UserId = userId.(uint)
+
+	// 待识别的文件：上传文件或者直接给出存储器中的引用
+	if fileHeader, err = c.FormFile("file"); err == nil {
+		if fileHeader.Size > config.Cfg.MaxUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"errno":1,
+				"message": "上传的文件过大",
+			})
+			return
+		}
+		if file, err = fileHeader.Open(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+		blobName = path.Join("pipeline", strconv.Itoa(int(pipeline.UserId)), pipeline.PipelineName, strconv.FormatInt(time.Now().UnixNano(), 10) + strings.ToLower(path.Ext(fileHeader.Filename)))
+		pipeline.InputRef, err = blobStore.Store.Put(blobName, file)
+		_ = file.Close()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errno":1,
+				"message":err.Error(),
+			})
+			return
+		}
+	} else if pipeline.InputRef = c.PostForm("input_ref"); !validPipelineRef(pipeline) {
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message": "缺少待识别的文件file或input_ref不合法",
+		})
+		return
+	}
+
+	if err = pipelineManager.PM.SavePipeline(pipeline); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errno":1,
+			"message":err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"errno": 0,
+		"message": "流水线已提交",
+		"data": pipeline,
+	})
+}
+
+// GET 获取流水线及每个步骤的状态
+func GetPipeline(c *gin.Context)  {
+	var (
+		err 			error
+		ok				bool
+		userId			interface{}
+		pipelineId		int64
+		pipeline		*common.Pipeline
+		progress		*common.PipelineProgress
+	)
+
+	if pipelineId, err = strconv.ParseInt(c.Param("id"), 10, 64); err != nil{
+		c.JSON(http.StatusCreated, gin.H{
+			"errno":1,
+			"message":"流水线id不合法",
+			"data":nil,
+		})
+		return
+	}
+
+	if userId, ok = c.Get("UserId"); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errno": 1,
+			"message": "请先登录后携带token以获取UserId",
+			"data":nil,
+		})
+		return
+	}
+
+	if pipeline, err = pipelineManager.PM.GetPipeline(pipelineId); err == pipelineManager.ERROR_PIPELINE_NOT_FOUND || (err == nil && pipeline.UserId != userId.(uint)){
+		c.JSON(http.StatusNotFound, gin.H{
+			"errno":1,
+			"message":"流水线不存在",
+			"data":nil,
+		})
+		return
+	}else if err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+
+	if progress, err = pipelineManager.PM.GetPipelineProgress(pipeline); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"errno":0,
+		"message":"success",
+		"data":progress,
+	})
+}
+
+// GET 获取垃圾回收报告(Leader归档并回收已结束任务的etcd key的统计)
+func GetGcReport(c *gin.Context)  {
+	var (
+		err 			error
+		gcReport		*common.GcReport
+	)
+
+	if gcReport, err = garbageCollector.GC.GetReport(); err != nil{
+		c.JSON(http.StatusAccepted, gin.H{
+			"errno":1,
+			"message":err.Error(),
+			"data":nil,
+		})
+	}else{
+		c.JSON(http.StatusOK, gin.H{
+			"errno":0,
+			"message":"success",
+			"data":gcReport,
 		})
 	}
-}
\ No newline at end of file
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"crack_front/src/config"
 	"crack_front/src/master/alerter"
+	"crack_front/src/master/assigner"
+	"crack_front/src/master/garbageCollector"
 	"crack_front/src/master/logger"
+	"crack_front/src/master/pipelineManager"
+	"crack_front/src/master/scheduleManager"
+	"crack_front/src/master/segmenter"
 	"errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
@@ -128,9 +133,14 @@ func (This *Elector) loop ()  {
 		}
 
 		// 成为了Leader
-		// 警报器随着FAIL_GET_LOCK的cancelFunc而关闭
+		// 警报器、视频切分器的合并循环、定时任务的触发循环、流水线的放行循环、垃圾回收循环和任务分配循环随着FAIL_GET_LOCK的cancelFunc而关闭
 		logger.Logger.InfoLog("I am Leader")
 		go alerter.Alert.Start(ctx)
+		go segmenter.VS.Start(ctx)
+		go scheduleManager.SM.Start(ctx)
+		go pipelineManager.PM.Start(ctx)
+		go garbageCollector.GC.Start(ctx)
+		go assigner.AS.Start(ctx)
 
 		// 监听Leader退出
 		select {
@@ -0,0 +1,318 @@
+package garbageCollector
+
+import (
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logManager"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"encoding/json"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 垃圾回收器。Leader定期找出结束超过Retention的任务状态记录，把任务归档到MongoDB后
+// 在一个事务中删除状态记录以及属于该任务的任务、成功通知、失败通知的key
+// 同时把过了最晚开始时间还没有被抢占的任务置为过期失败
+// worker重启时不再重放这些任务，查询任务状态时从MongoDB读取
+
+type GarbageCollector struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 一轮回收的统计
+type gcRound struct {
+	tasks 				int
+	keys 				int
+	bytes 				int64
+}
+
+// 读出属于该任务的key(同名的新任务会覆盖这些key，只回收任务id相同的)
+func (This *GarbageCollector) taskKeys(status *common.TaskStatus) (kvPairs []*mvccpb.KeyValue, taskKv *mvccpb.KeyValue, err error) {
+	var (
+		userTask 			string
+		keys 				[]string
+		key 				string
+		ops 				[]clientv3.Op
+		txnResp 			*clientv3.TxnResponse
+		index 				int
+		kvPair 				*mvccpb.KeyValue
+		task 				*common.Task
+	)
+	userTask = path.Join(status.TaskType, strconv.Itoa(int(status.UserId)), status.TaskName)
+	keys = []string{
+		path.Join(config.Cfg.TaskDir, userTask),
+		path.Join(config.Cfg.FinishDir, userTask),
+		path.Join(config.Cfg.FailDir, userTask),
+	}
+	for _, key = range keys {
+		ops = append(ops, clientv3.OpGet(key))
+	}
+	if txnResp, err = This.kv.Txn(context.TODO()).Then(ops...).Commit(); err != nil {
+		return
+	}
+	for index = range keys {
+		if len(txnResp.Responses[index].GetResponseRange().Kvs) == 0 {
+			continue
+		}
+		kvPair = txnResp.Responses[index].GetResponseRange().Kvs[0]
+		task = &common.Task{}
+		if err = json.Unmarshal(kvPair.Value, task); err != nil || task.TaskId != status.TaskId {
+			err = nil
+			continue
+		}
+		kvPairs = append(kvPairs, kvPair)
+		if index == 0 {
+			taskKv = kvPair
+		}
+	}
+	return kvPairs, taskKv, nil
+}
+
+// 归档并回收一个任务，其中任何一个key被并发修改时放弃，下一轮再回收
+func (This *GarbageCollector) collect(status *common.TaskStatus, statusKv *mvccpb.KeyValue, round *gcRound) (err error) {
+	var (
+		kvPairs 			[]*mvccpb.KeyValue
+		taskKv 				*mvccpb.KeyValue
+		kvPair 				*mvccpb.KeyValue
+		archive 			*common.TaskArchive
+		cmps 				[]clientv3.Cmp
+		ops 				[]clientv3.Op
+		txnResp 			*clientv3.TxnResponse
+	)
+	if kvPairs, taskKv, err = This.taskKeys(status); err != nil {
+		return
+	}
+
+	// 先归档，归档失败时不删除
+	archive = &common.TaskArchive{
+		TaskId:      status.TaskId,
+		Status:      status,
+		ArchiveTime: time.Now().UnixNano() / 1000 / 1000,
+	}
+	if taskKv != nil {
+		archive.Task = string(taskKv.Value)
+	}
+	if err = logManager.LM.ArchiveTask(archive); err != nil {
+		return
+	}
+
+	kvPairs = append(kvPairs, statusKv)
+	for _, kvPair = range kvPairs {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(kvPair.Key)), "=", kvPair.ModRevision))
+		ops = append(ops, clientv3.OpDelete(string(kvPair.Key)))
+	}
+	if txnResp, err = This.kv.Txn(context.TODO()).If(cmps...).Then(ops...).Commit(); err != nil {
+		return
+	}
+	if !txnResp.Succeeded {
+		return nil
+	}
+
+	round.tasks++
+	for _, kvPair = range kvPairs {
+		round.keys++
+		round.bytes += int64(len(kvPair.Key) + len(kvPair.Value))
+	}
+	return nil
+}
+
+// 更新回收报告
+func (This *GarbageCollector) report(round *gcRound) (err error) {
+	var (
+		gcReport 			*common.GcReport
+		reportValue 		[]byte
+	)
+	if gcReport, err = This.GetReport(); err != nil {
+		return
+	}
+	gcReport.LastRunTime = time.Now().UnixNano() / 1000 / 1000
+	gcReport.LastTasks = round.tasks
+	gcReport.LastKeys = round.keys
+	gcReport.LastBytes = round.bytes
+	gcReport.TotalTasks += int64(round.tasks)
+	gcReport.TotalKeys += int64(round.keys)
+	gcReport.TotalBytes += round.bytes
+	if reportValue, err = json.Marshal(gcReport); err != nil {
+		return
+	}
+	_, err = This.kv.Put(context.TODO(), config.Cfg.GcReportKey, string(reportValue))
+	return
+}
+
+// 查询回收报告
+func (This *GarbageCollector) GetReport() (gcReport *common.GcReport, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.GcReportKey); err != nil {
+		return
+	}
+	gcReport = &common.GcReport{}
+	if len(getResp.Kvs) != 0 {
+		if err = json.Unmarshal(getResp.Kvs[0].Value, gcReport); err != nil {
+			return nil, err
+		}
+	}
+	return gcReport, nil
+}
+
+// 一轮回收
+func (This *GarbageCollector) collectAll() {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		status 				*common.TaskStatus
+		expire 				int64
+		round 				gcRound
+		err 				error
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.StatusDir, clientv3.WithPrefix()); err != nil {
+		logger.Logger.WarnLog("读取任务状态失败:", err)
+		return
+	}
+	expire = time.Now().Add(-config.Cfg.GcRetention).UnixNano() / 1000 / 1000
+	for _, kvPair = range getResp.Kvs {
+		if round.tasks >= config.Cfg.GcBatchSize {
+			break
+		}
+		status = &common.TaskStatus{}
+		if err = json.Unmarshal(kvPair.Value, status); err != nil {
+			logger.Logger.InfoLog("任务状态反序列化错误...已丢弃该错误:", err.Error())
+			continue
+		}
+		if !status.State.IsFinal() || status.FinishTime == 0 || status.FinishTime > expire {
+			continue
+		}
+		if err = This.collect(status, kvPair, &round); err != nil {
+			logger.Logger.WarnLog("回收任务失败, task_id=", status.TaskId, "err=", err)
+		}
+	}
+
+	if round.tasks == 0 {
+		return
+	}
+	logger.Logger.InfoLog("垃圾回收: 归档任务", round.tasks, "个, 删除key", round.keys, "个, 回收", round.bytes, "字节")
+	if err = This.report(&round); err != nil {
+		logger.Logger.WarnLog("更新回收报告失败:", err)
+	}
+}
+
+// 过期失败一个还没有被抢占的任务，通知失败并报警。状态、失败通知和报警在同一个事务中写入，worker同时抢占时只有一方成功
+func (This *GarbageCollector) expire(task *common.Task, taskValue []byte) (err error) {
+	var (
+		status 				*common.TaskStatus
+	)
+	if status, err = taskManager.TM.ExpireTask(task, taskValue); err != nil {
+		return
+	}
+	if err = logManager.LM.SaveTaskStatus(status); err != nil {
+		logger.Logger.WarnLog("镜像任务状态失败, task_id=", task.TaskId, "err=", err)
+	}
+	return nil
+}
+
+// 过了最晚开始时间还在等待的任务(如没有worker的标签匹配它的选择器)不会被任何worker执行，由Leader置为过期失败
+func (This *GarbageCollector) expireAll() {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		task 				*common.Task
+		expired 			[]*common.Task
+		values 				map[int64][]byte
+		taskIds 			[]int64
+		statuses 			map[int64]*common.TaskStatus
+		status 				*common.TaskStatus
+		now 				int64
+		err 				error
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.TaskDir, clientv3.WithPrefix()); err != nil {
+		logger.Logger.WarnLog("读取任务失败:", err)
+		return
+	}
+	now = time.Now().UnixNano() / 1000 / 1000
+	values = make(map[int64][]byte)
+	for _, kvPair = range getResp.Kvs {
+		task = &common.Task{}
+		if err = json.Unmarshal(kvPair.Value, task); err != nil || !task.Expired(now) {
+			continue
+		}
+		expired = append(expired, task)
+		values[task.TaskId] = kvPair.Value
+		taskIds = append(taskIds, task.TaskId)
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if statuses, err = taskManager.TM.GetTaskStatuses(taskIds); err != nil {
+		logger.Logger.WarnLog("读取任务状态失败:", err)
+		return
+	}
+	for _, task = range expired {
+		if status = statuses[task.TaskId]; status == nil || status.State != common.TaskPending {
+			continue
+		}
+		if err = This.expire(task, values[task.TaskId]); err != nil {
+			logger.Logger.WarnLog("过期任务失败, task_id=", task.TaskId, "err=", err)
+			continue
+		}
+		logger.Logger.InfoLog("任务过期:", task.TaskName, "task_id=", task.TaskId)
+	}
+}
+
+// 成为Leader后启动，ctx被取消(失去Leader)时退出
+func (This *GarbageCollector) Start(ctx context.Context) {
+	var (
+		ticker 				*time.Ticker
+	)
+	ticker = time.NewTicker(config.Cfg.GcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			This.expireAll()
+			This.collectAll()
+		}
+	}
+}
+
+// 垃圾回收器单例
+var (
+	GC 					*GarbageCollector
+)
+
+func InitGarbageCollector() (err error) {
+	if GC == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		GC = &GarbageCollector{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package jobManager
+
+import (
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"encoding/json"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 批量任务管理器。一个批量任务展开为多个子任务放入任务目录，批量任务记录保存在JobDir/批量任务id
+
+var (
+	ERROR_JOB_NOT_FOUND			error = errors.New("批量任务不存在")
+	ERROR_JOB_CANCELLED			error = errors.New("批量任务已经被取消")
+)
+
+// CAS冲突时的最大重试次数
+const maxCasRetry = 5
+
+type JobManager struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 批量任务在etcd中的key
+func jobKey(jobId int64) string {
+	return path.Join(config.Cfg.JobDir, strconv.FormatInt(jobId, 10))
+}
+
+// 子任务名称
+func childTaskName(jobId int64, index int) string {
+	return "j" + strconv.FormatInt(jobId, 10) + "_" + strconv.Itoa(index)
+}
+
+// 创建批量任务，inputRefs为每个子任务待识别文件的引用
+func (This *JobManager) SaveJob(job *common.Job, inputRefs []string) (err error) {
+	var (
+		index 				int
+		inputRef 			string
+		task 				*common.Task
+		ops 				[]clientv3.Op
+		taskOps 			[]clientv3.Op
+		jobValue 			[]byte
+	)
+	if job.JobId, err = taskManager.TM.NewTaskId(); err != nil {
+		return
+	}
+	job.CreateTime = time.Now().UnixNano() / 1000 / 1000
+
+	// 预先分配子任务id
+	job.Tasks = make([]*common.Task, 0, len(inputRefs))
+	for index, inputRef = range inputRefs {
+		task = &common.Task{
+			TaskType:    job.TaskType,
+			UserId:      job.UserId,
+			TaskName:    childTaskName(job.JobId, index),
+			TaskTimeOut: job.TaskTimeOut,
+			InputRef:    inputRef,
+			JobId:       job.JobId,
+			Priority:    job.Priority,
+			Selector:    job.Selector,
+			MaxRetries:  job.MaxRetries,
+			RetryBackoff: job.RetryBackoff,
+			NotBefore:   job.NotBefore,
+			Deadline:    job.Deadline,
+		}
+		if task.TaskId, err = taskManager.TM.NewTaskId(); err != nil {
+			return
+		}
+		job.Tasks = append(job.Tasks, task)
+	}
+
+	// 批量任务记录和展开的子任务在同一个事务中写入，失败时什么都不留下
+	for _, task = range job.Tasks {
+		if taskOps, err = taskManager.TM.SaveTaskOps(task); err != nil {
+			return
+		}
+		ops = append(ops, taskOps...)
+	}
+	if jobValue, err = json.Marshal(job); err != nil {
+		return
+	}
+	ops = append(ops, clientv3.OpPut(jobKey(job.JobId), string(jobValue)))
+	return taskManager.TM.CommitOps(ops)
+}
+
+// 查询批量任务，返回该记录的修改版本号
+func (This *JobManager) getJob(jobId int64) (job *common.Job, modRevision int64, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+	)
+	if getResp, err = This.kv.Get(context.TODO(), jobKey(jobId)); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, 0, ERROR_JOB_NOT_FOUND
+	}
+	job = &common.Job{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, job); err != nil {
+		return nil, 0, err
+	}
+	return job, getResp.Kvs[0].ModRevision, nil
+}
+
+// 查询批量任务
+func (This *JobManager) GetJob(jobId int64) (job *common.Job, err error) {
+	job, _, err = This.getJob(jobId)
+	return
+}
+
+// 以修改版本号做CAS把批量任务标记为已取消，避免覆盖同时写入的修改。返回标记之前是否已经取消
+func (This *JobManager) markCancelled(jobId int64) (job *common.Job, cancelled bool, err error) {
+	var (
+		modRevision 		int64
+		jobValue 			[]byte
+		txnResp 			*clientv3.TxnResponse
+		retry 				int
+	)
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if job, modRevision, err = This.getJob(jobId); err != nil {
+			return nil, false, err
+		}
+		if job.Cancelled {
+			return job, true, nil
+		}
+		job.Cancelled = true
+		if jobValue, err = json.Marshal(job); err != nil {
+			return nil, false, err
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(jobKey(jobId)), "=", modRevision)).Then(
+			clientv3.OpPut(jobKey(jobId), string(jobValue))).Commit(); err != nil {
+			return nil, false, err
+		}
+		if txnResp.Succeeded {
+			return job, false, nil
+		}
+	}
+	return nil, false, taskManager.ERROR_STATE_CONFLICT
+}
+
+// 汇总批量任务的进度
+func (This *JobManager) GetJobProgress(job *common.Job) (progress *common.JobProgress, err error) {
+	var (
+		status 				*common.TaskStatus
+	)
+	progress = &common.JobProgress{
+		Job:   job,
+		Total: len(job.Tasks),
+	}
+	if progress.Children, err = taskManager.TM.LookupTaskStatuses(job.Tasks); err != nil {
+		return nil, err
+	}
+	for _, status = range progress.Children {
+		switch status.State {
+		case common.TaskSucceeded:
+			progress.Done++
+		case common.TaskFailed, common.TaskKilled, common.TaskTimedOut, common.TaskCancelled:
+			progress.Failed++
+		case common.TaskClaimed, common.TaskRunning:
+			progress.Running++
+		default:
+			progress.Pending++
+		}
+	}
+	return
+}
+
+// 取消批量任务：取消还没有被抢占的子任务，通过强杀目录杀死正在执行的子任务
+// 有子任务没能停止时返回错误，再次取消时重试这些子任务；已经取消并且子任务全部结束时返回ERROR_JOB_CANCELLED
+func (This *JobManager) CancelJob(job *common.Job) (err error) {
+	var (
+		cancelled 			bool
+		children 			[]*common.TaskStatus
+		index 				int
+		status 				*common.TaskStatus
+		stopped 			int
+		failedIds 			[]int64
+	)
+	if job, cancelled, err = This.markCancelled(job.JobId); err != nil {
+		return
+	}
+
+	if children, err = taskManager.TM.LookupTaskStatuses(job.Tasks); err != nil {
+		return
+	}
+	for index, status = range children {
+		if status.State.IsFinal() {
+			continue
+		}
+		stopped++
+		// 还没有被抢占的子任务直接取消，已经开始执行的子任务强杀
+		if _, err = taskManager.TM.CancelTask(status.TaskId); err != taskManager.ERROR_TASK_STARTED {
+			if err != nil {
+				logger.Logger.WarnLog("取消子任务失败, task_id=", status.TaskId, "err=", err)
+				failedIds = append(failedIds, status.TaskId)
+			}
+			continue
+		}
+		if err = taskManager.TM.KillTask(job.Tasks[index]); err != nil {
+			logger.Logger.WarnLog("强杀子任务失败, task_id=", status.TaskId, "err=", err)
+			failedIds = append(failedIds, status.TaskId)
+		}
+	}
+	if len(failedIds) != 0 {
+		return fmt.Errorf("%d个子任务没有停止, task_id=%v", len(failedIds), failedIds)
+	}
+	if cancelled && stopped == 0 {
+		return ERROR_JOB_CANCELLED
+	}
+	return nil
+}
+
+// 批量任务管理器单例
+var (
+	JM 					*JobManager
+)
+
+func InitJobManager() (err error) {
+	if JM == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		JM = &JobManager{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
@@ -12,12 +12,18 @@ import (
 
 // 日志表名
 var (
-	collection		string = "log"
+	collection			string = "log"
+	resultCollection	string = "result"
+	statusCollection	string = "status"
+	archiveCollection	string = "archive"
 )
 
 type LogManager struct {
 	mongoClient 			*mongo.Client
 	mongoCollection			*mongo.Collection
+	resultCollection		*mongo.Collection
+	statusCollection		*mongo.Collection
+	archiveCollection		*mongo.Collection
 }
 
 func (This *LogManager) newTaskNameFilter(taskName string) bson.D {
@@ -60,6 +66,78 @@ func (This *LogManager) QueryTaskLog (taskName string, skip int64, limit int64)
 }
 
 
+// 查询任务每次执行保存的产物文件(按执行的先后)，没有产物文件的执行不返回
+func (This *LogManager) QueryTaskArtifacts (taskId int64) (taskArtifacts []*common.TaskArtifacts, err error) {
+	var(
+		cursor			*mongo.Cursor
+		findOpt			*options.FindOptions
+		taskLog 		*common.TaskLog
+	)
+	taskArtifacts = make([]*common.TaskArtifacts, 0)
+
+	findOpt = &options.FindOptions{
+		Sort:                bson.D{{Key: "attempt", Value: 1}, {Key: "finish_time", Value: 1}},
+	}
+	if cursor, err = This.mongoCollection.Find(context.TODO(), bson.M{"task_id": taskId, "artifacts.0": bson.M{"$exists": true}}, findOpt); err != nil{
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	for cursor.Next(context.TODO()){
+		taskLog = &common.TaskLog{}
+		if err = cursor.Decode(taskLog); err != nil{
+			logger.Logger.WarnLog("日志反序列化时失败，已忽略该条日志:", err)
+			continue
+		}
+		taskArtifacts = append(taskArtifacts, &common.TaskArtifacts{
+			Attempt:    taskLog.Attempt,
+			FinishTime: taskLog.FinishTime,
+			Artifacts:  taskLog.Artifacts,
+		})
+	}
+	return taskArtifacts, cursor.Err()
+}
+
+// 查询某个用户的某个任务的识别结果，不存在时返回mongo.ErrNoDocuments
+func (This *LogManager) QueryTaskResult (taskId int64, userId uint) (result *common.CrackResult, err error) {
+	result = &common.CrackResult{}
+	if err = This.resultCollection.FindOne(context.TODO(), bson.M{"task_id": taskId, "user_id": userId}).Decode(result); err != nil{
+		return nil, err
+	}
+	return
+}
+
+
+// 查询worker镜像到MongoDB中的任务状态，不存在时返回mongo.ErrNoDocuments
+func (This *LogManager) QueryTaskStatus (taskId int64) (status *common.TaskStatus, err error) {
+	status = &common.TaskStatus{}
+	if err = This.statusCollection.FindOne(context.TODO(), bson.M{"task_id": taskId}).Decode(status); err != nil{
+		return nil, err
+	}
+	return
+}
+
+// 保存由master产生的识别结果(如视频分段合并后的结果)
+func (This *LogManager) SaveTaskResult (result *common.CrackResult) (err error) {
+	_, err = This.resultCollection.ReplaceOne(context.TODO(), bson.M{"task_id": result.TaskId}, result, options.Replace().SetUpsert(true))
+	return
+}
+
+
+// 镜像由master修改的任务状态
+func (This *LogManager) SaveTaskStatus (status *common.TaskStatus) (err error) {
+	_, err = This.statusCollection.ReplaceOne(context.TODO(), bson.M{"task_id": status.TaskId}, status, options.Replace().SetUpsert(true))
+	return
+}
+
+// 归档已结束的任务(etcd中的key被回收之前)，同时镜像其最终状态，之后查询状态时从MongoDB读取
+func (This *LogManager) ArchiveTask (archive *common.TaskArchive) (err error) {
+	if _, err = This.archiveCollection.ReplaceOne(context.TODO(), bson.M{"task_id": archive.TaskId}, archive, options.Replace().SetUpsert(true)); err != nil{
+		return
+	}
+	return This.SaveTaskStatus(archive.Status)
+}
+
 // 日志管理器单例
 var (
 	LM				*LogManager
@@ -83,7 +161,10 @@ func InitLogManager() (err error) {
 		// 赋值单例
 		LM = &LogManager{
 			mongoClient:     client,
-			mongoCollection: client.Database(config.Cfg.MongoDB_DatabaseURI).Collection(collection),
+			mongoCollection: client.Database(config.Cfg.MongoDB_DatabaseName).Collection(collection),
+			resultCollection: client.Database(config.Cfg.MongoDB_DatabaseName).Collection(resultCollection),
+			statusCollection: client.Database(config.Cfg.MongoDB_DatabaseName).Collection(statusCollection),
+			archiveCollection: client.Database(config.Cfg.MongoDB_DatabaseName).Collection(archiveCollection),
 		}
 	}
 	return nil
@@ -0,0 +1,399 @@
+package pipelineManager
+
+import (
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logManager"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 流水线管理器。流水线记录保存在PipelineDir/流水线id
+// 创建流水线时为每个步骤创建pending状态记录，只把没有上游的步骤放入任务目录
+// Leader定期检查：上游全部成功的步骤带着上游的输出文件放入任务目录，有步骤失败时整条流水线失败
+
+var (
+	ERROR_PIPELINE_NOT_FOUND		error = errors.New("流水线不存在")
+	ERROR_PIPELINE_STEPS			error = errors.New("流水线步骤不合法")
+	ERROR_PIPELINE_CYCLE			error = errors.New("流水线步骤之间存在循环依赖")
+)
+
+type PipelineManager struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 流水线在etcd中的key
+func pipelineKey(pipelineId int64) string {
+	return path.Join(config.Cfg.PipelineDir, strconv.FormatInt(pipelineId, 10))
+}
+
+// 步骤的任务名称
+func stepTaskName(pipelineId int64, stepName string) string {
+	return "p" + strconv.FormatInt(pipelineId, 10) + "_" + stepName
+}
+
+// 校验步骤：名称唯一、依赖的步骤存在、没有循环依赖
+func VerifySteps(steps []*common.PipelineStep) (err error) {
+	var (
+		step 				*common.PipelineStep
+		dep 				string
+		indegree 			map[string]int
+		downstream 			map[string][]string
+		queue 				[]string
+		name 				string
+		visited 			int
+		ok 					bool
+	)
+	if len(steps) == 0 || len(steps) > config.Cfg.MaxPipelineSteps {
+		return ERROR_PIPELINE_STEPS
+	}
+	indegree = make(map[string]int, len(steps))
+	downstream = make(map[string][]string, len(steps))
+	for _, step = range steps {
+		if step == nil || !common.VerifyTaskName(step.Name) || !common.VerifyStepType(step.TaskType) ||
+			!common.VerifyTaskPriority(step.Priority) || !common.VerifyTaskRetry(step.MaxRetries, step.RetryBackoff) ||
+			!common.VerifySelector(step.Selector) {
+			return ERROR_PIPELINE_STEPS
+		}
+		if _, ok = indegree[step.Name]; ok {
+			return ERROR_PIPELINE_STEPS
+		}
+		indegree[step.Name] = 0
+	}
+	for _, step = range steps {
+		for _, dep = range step.DependsOn {
+			if _, ok = indegree[dep]; !ok || dep == step.Name {
+				return ERROR_PIPELINE_STEPS
+			}
+			indegree[step.Name]++
+			downstream[dep] = append(downstream[dep], step.Name)
+		}
+	}
+
+	// 拓扑排序，所有步骤都能被访问到时没有环
+	for name = range indegree {
+		if indegree[name] == 0 {
+			queue = append(queue, name)
+		}
+	}
+	for len(queue) != 0 {
+		name, queue = queue[0], queue[1:]
+		visited++
+		for _, dep = range downstream[name] {
+			if indegree[dep]--; indegree[dep] == 0 {
+				queue = append(queue, dep)
+			}
+		}
+	}
+	if visited != len(steps) {
+		return ERROR_PIPELINE_CYCLE
+	}
+	return nil
+}
+
+// 步骤对应的任务，inputRefs为上游步骤的输出文件
+func stepTask(pipeline *common.Pipeline, step *common.PipelineStep, inputRefs []string) *common.Task {
+	return &common.Task{
+		TaskType:     step.TaskType,
+		UserId:       pipeline.UserId,
+		TaskName:     stepTaskName(pipeline.PipelineId, step.Name),
+		TaskId:       step.TaskId,
+		TaskTimeOut:  step.TaskTimeOut,
+		InputRef:     pipeline.InputRef,
+		InputRefs:    inputRefs,
+		PipelineId:   pipeline.PipelineId,
+		Priority:     step.Priority,
+		Selector:     step.Selector,
+		MaxRetries:   step.MaxRetries,
+		RetryBackoff: step.RetryBackoff,
+	}
+}
+
+// 创建流水线：在同一个事务中写入流水线记录、所有步骤的pending状态记录和没有上游的步骤
+func (This *PipelineManager) SavePipeline(pipeline *common.Pipeline) (err error) {
+	var (
+		step 				*common.PipelineStep
+		ops 				[]clientv3.Op
+		op 					clientv3.Op
+		pipelineValue 		[]byte
+	)
+	if pipeline.PipelineId, err = taskManager.TM.NewTaskId(); err != nil {
+		return
+	}
+	pipeline.State = common.TaskRunning
+	pipeline.CreateTime = time.Now().UnixNano() / 1000 / 1000
+
+	for _, step = range pipeline.Steps {
+		if step.TaskId, err = taskManager.TM.NewTaskId(); err != nil {
+			return
+		}
+		if op, err = taskManager.TM.PendingStatusOp(stepTask(pipeline, step, nil)); err != nil {
+			return
+		}
+		ops = append(ops, op)
+		if len(step.DependsOn) == 0 {
+			if op, err = taskManager.TM.PutTaskOp(stepTask(pipeline, step, nil)); err != nil {
+				return
+			}
+			ops = append(ops, op)
+			step.Released = true
+		}
+	}
+
+	if pipelineValue, err = json.Marshal(pipeline); err != nil {
+		return
+	}
+	ops = append(ops, clientv3.OpPut(pipelineKey(pipeline.PipelineId), string(pipelineValue)))
+	_, err = This.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return
+}
+
+// 查询流水线
+func (This *PipelineManager) GetPipeline(pipelineId int64) (pipeline *common.Pipeline, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+	)
+	if getResp, err = This.kv.Get(context.TODO(), pipelineKey(pipelineId)); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, ERROR_PIPELINE_NOT_FOUND
+	}
+	pipeline = &common.Pipeline{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, pipeline); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// 每个步骤的状态
+func (This *PipelineManager) stepStatuses(pipeline *common.Pipeline) (statuses []*common.TaskStatus, err error) {
+	var (
+		tasks 				[]*common.Task
+		step 				*common.PipelineStep
+	)
+	tasks = make([]*common.Task, 0, len(pipeline.Steps))
+	for _, step = range pipeline.Steps {
+		tasks = append(tasks, stepTask(pipeline, step, nil))
+	}
+	return taskManager.TM.LookupTaskStatuses(tasks)
+}
+
+// 查询流水线的进度
+func (This *PipelineManager) GetPipelineProgress(pipeline *common.Pipeline) (progress *common.PipelineProgress, err error) {
+	progress = &common.PipelineProgress{
+		Pipeline: pipeline,
+	}
+	if progress.Steps, err = This.stepStatuses(pipeline); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// 流水线失败：还没放行的步骤置为killed，已放行但没有结束的步骤强杀
+func (This *PipelineManager) fail(pipeline *common.Pipeline, statuses []*common.TaskStatus, reason string) {
+	var (
+		index 				int
+		step 				*common.PipelineStep
+		status 				*common.TaskStatus
+		err 				error
+	)
+	pipeline.State = common.TaskFailed
+	pipeline.Error = reason
+	pipeline.FinishTime = time.Now().UnixNano() / 1000 / 1000
+	for index, step = range pipeline.Steps {
+		if statuses[index].State.IsFinal() {
+			continue
+		}
+		if !step.Released {
+			if status, err = taskManager.TM.TransitionTaskStatus(step.TaskId, common.TaskKilled, "上游步骤失败"); err == nil {
+				_ = logManager.LM.SaveTaskStatus(status)
+			}
+		} else if err = taskManager.TM.KillTask(stepTask(pipeline, step, nil)); err != nil {
+			logger.Logger.WarnLog("强杀流水线步骤失败, task_id=", step.TaskId, "err=", err)
+		}
+	}
+}
+
+// 检查一条流水线，放行上游全部成功的步骤，整条流水线结束时更新流水线状态
+func (This *PipelineManager) check(pipeline *common.Pipeline, modRevision int64) (err error) {
+	var (
+		statuses 			[]*common.TaskStatus
+		byName 				map[string]*common.TaskStatus
+		index 				int
+		step 				*common.PipelineStep
+		status 				*common.TaskStatus
+		dep 				string
+		ready 				bool
+		inputRefs 			[]string
+		op 					clientv3.Op
+		ops 				[]clientv3.Op
+		succeeded 			int
+		changed 			bool
+		failed 				bool
+		pipelineValue 		[]byte
+		txnResp 			*clientv3.TxnResponse
+	)
+	if statuses, err = This.stepStatuses(pipeline); err != nil {
+		return
+	}
+	byName = make(map[string]*common.TaskStatus, len(statuses))
+	for index, step = range pipeline.Steps {
+		byName[step.Name] = statuses[index]
+	}
+
+	// 任何一个步骤失败时整条流水线失败，不再放行其他步骤
+	for index, step = range pipeline.Steps {
+		status = statuses[index]
+		switch status.State {
+		case common.TaskSucceeded:
+			succeeded++
+		case common.TaskFailed, common.TaskKilled, common.TaskTimedOut, common.TaskCancelled:
+			This.fail(pipeline, statuses, "步骤" + step.Name + " " + string(status.State) + ": " + status.Error)
+			failed = true
+		}
+		if failed {
+			break
+		}
+	}
+
+	changed = failed
+	for _, step = range pipeline.Steps {
+		if failed {
+			break
+		}
+		if step.Released {
+			continue
+		}
+
+		// 上游全部成功时放行，上游的输出文件按依赖顺序作为输入
+		ready = true
+		inputRefs = nil
+		for _, dep = range step.DependsOn {
+			if byName[dep].State != common.TaskSucceeded {
+				ready = false
+				break
+			}
+			inputRefs = append(inputRefs, byName[dep].OutputRefs...)
+		}
+		if !ready {
+			continue
+		}
+		if op, err = taskManager.TM.PutTaskOp(stepTask(pipeline, step, inputRefs)); err != nil {
+			return
+		}
+		ops = append(ops, op)
+		step.Released = true
+		changed = true
+	}
+	if !changed && succeeded == len(pipeline.Steps) {
+		pipeline.State = common.TaskSucceeded
+		pipeline.FinishTime = time.Now().UnixNano() / 1000 / 1000
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	// 流水线记录被并发修改(Leader切换)时放弃，下次检查时重新计算
+	if pipelineValue, err = json.Marshal(pipeline); err != nil {
+		return
+	}
+	ops = append(ops, clientv3.OpPut(pipelineKey(pipeline.PipelineId), string(pipelineValue)))
+	if txnResp, err = This.kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.ModRevision(pipelineKey(pipeline.PipelineId)), "=", modRevision)).Then(
+		ops...).Commit(); err != nil {
+		return
+	}
+	if txnResp.Succeeded && pipeline.State != common.TaskRunning {
+		logger.Logger.InfoLog("流水线结束, pipeline_id=", pipeline.PipelineId, "state=", pipeline.State)
+	}
+	return nil
+}
+
+// 检查所有运行中的流水线
+func (This *PipelineManager) checkAll() {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		pipeline 			*common.Pipeline
+		err 				error
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.PipelineDir, clientv3.WithPrefix()); err != nil {
+		logger.Logger.WarnLog("读取流水线失败:", err)
+		return
+	}
+	for _, kvPair = range getResp.Kvs {
+		pipeline = &common.Pipeline{}
+		if err = json.Unmarshal(kvPair.Value, pipeline); err != nil {
+			logger.Logger.InfoLog("流水线反序列化错误...已丢弃该错误:", err.Error())
+			continue
+		}
+		if pipeline.State != common.TaskRunning {
+			continue
+		}
+		if err = This.check(pipeline, kvPair.ModRevision); err != nil {
+			logger.Logger.WarnLog("检查流水线失败, pipeline_id=", pipeline.PipelineId, "err=", err)
+		}
+	}
+}
+
+// 成为Leader后启动，ctx被取消(失去Leader)时退出
+func (This *PipelineManager) Start(ctx context.Context) {
+	var (
+		ticker 				*time.Ticker
+	)
+	ticker = time.NewTicker(config.Cfg.PipelineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			This.checkAll()
+		}
+	}
+}
+
+// 流水线管理器单例
+var (
+	PM 					*PipelineManager
+)
+
+func InitPipelineManager() (err error) {
+	if PM == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		PM = &PipelineManager{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
@@ -43,6 +43,40 @@ func init() {
 			adminRouter.GET("/log", controller.QueryTaskLog)
 
 			adminRouter.GET("/worker", controller.GetWorkers)
+
+			adminRouter.GET("/worker/capacity", controller.GetWorkerCapacities)
+
+			adminRouter.GET("/gc", controller.GetGcReport)
+
+			adminRouter.GET("/task/:id", controller.GetTaskStatus)
+
+			adminRouter.GET("/task/:id/result", controller.GetTaskResult)
+
+			adminRouter.POST("/task/:id/cancel", controller.CancelTask)
+
+			adminRouter.GET("/task/:id/stream", controller.StreamTask)
+
+			adminRouter.GET("/task/:id/artifacts", controller.GetTaskArtifacts)
+
+			adminRouter.POST("/job", controller.SubmitJob)
+
+			adminRouter.GET("/job/:id", controller.GetJob)
+
+			adminRouter.POST("/job/:id/cancel", controller.CancelJob)
+
+			adminRouter.POST("/schedule", controller.CreateSchedule)
+
+			adminRouter.GET("/schedule", controller.GetSchedules)
+
+			adminRouter.GET("/schedule/:id", controller.GetSchedule)
+
+			adminRouter.POST("/schedule/:id/pause", controller.PauseSchedule)
+
+			adminRouter.POST("/schedule/:id/resume", controller.ResumeSchedule)
+
+			adminRouter.POST("/pipeline", controller.SubmitPipeline)
+
+			adminRouter.GET("/pipeline/:id", controller.GetPipeline)
 		}
 	}
 }
@@ -0,0 +1,312 @@
+package scheduleManager
+
+import (
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 定时任务管理器。定时任务记录保存在ScheduleDir/定时任务id
+// Leader定期检查到期的定时任务，把本次运行生成为普通任务放入任务目录，并计算下一次的计划时间
+
+var (
+	ERROR_SCHEDULE_NOT_FOUND		error = errors.New("定时任务不存在")
+	ERROR_SCHEDULE_NEVER			error = errors.New("cron表达式永远不会触发")
+)
+
+// CAS冲突时的最大重试次数
+const maxCasRetry = 5
+
+type ScheduleManager struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 定时任务在etcd中的key
+func scheduleKey(scheduleId int64) string {
+	return path.Join(config.Cfg.ScheduleDir, strconv.FormatInt(scheduleId, 10))
+}
+
+// 每次运行生成的任务名称
+func runTaskName(scheduleId int64, runCount int) string {
+	return "c" + strconv.FormatInt(scheduleId, 10) + "_" + strconv.Itoa(runCount)
+}
+
+// 计算from之后的下一次计划时间(ms)
+func nextTime(cronExpr string, from time.Time) (next int64, err error) {
+	var (
+		cron 				*common.CronExpr
+		t 					time.Time
+	)
+	if cron, err = common.ParseCron(cronExpr); err != nil {
+		return
+	}
+	if t = cron.Next(from); t.IsZero() {
+		return 0, ERROR_SCHEDULE_NEVER
+	}
+	return t.UnixNano() / 1000 / 1000, nil
+}
+
+// 保存定时任务记录
+func (This *ScheduleManager) putSchedule(schedule *common.Schedule) (err error) {
+	var (
+		scheduleValue 		[]byte
+	)
+	if scheduleValue, err = json.Marshal(schedule); err != nil {
+		return
+	}
+	_, err = This.kv.Put(context.TODO(), scheduleKey(schedule.ScheduleId), string(scheduleValue))
+	return
+}
+
+// 创建定时任务
+func (This *ScheduleManager) SaveSchedule(schedule *common.Schedule) (err error) {
+	if schedule.NextTime, err = nextTime(schedule.CronExpr, time.Now()); err != nil {
+		return
+	}
+	if schedule.ScheduleId, err = taskManager.TM.NewTaskId(); err != nil {
+		return
+	}
+	schedule.CreateTime = time.Now().UnixNano() / 1000 / 1000
+	return This.putSchedule(schedule)
+}
+
+// 查询定时任务，返回该记录的修改版本号
+func (This *ScheduleManager) getSchedule(scheduleId int64) (schedule *common.Schedule, modRevision int64, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+	)
+	if getResp, err = This.kv.Get(context.TODO(), scheduleKey(scheduleId)); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, 0, ERROR_SCHEDULE_NOT_FOUND
+	}
+	schedule = &common.Schedule{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, schedule); err != nil {
+		return nil, 0, err
+	}
+	return schedule, getResp.Kvs[0].ModRevision, nil
+}
+
+// 查询定时任务
+func (This *ScheduleManager) GetSchedule(scheduleId int64) (schedule *common.Schedule, err error) {
+	schedule, _, err = This.getSchedule(scheduleId)
+	return
+}
+
+// 查询某个用户的所有定时任务
+func (This *ScheduleManager) GetUserSchedules(userId uint) (schedules []*common.Schedule, err error) {
+	var (
+		schedule 			*common.Schedule
+		allSchedules 		[]*common.Schedule
+	)
+	if allSchedules, _, err = This.listSchedules(); err != nil {
+		return
+	}
+	schedules = make([]*common.Schedule, 0)
+	for _, schedule = range allSchedules {
+		if schedule.UserId == userId {
+			schedules = append(schedules, schedule)
+		}
+	}
+	return schedules, nil
+}
+
+// 查询所有定时任务及其修改版本号
+func (This *ScheduleManager) listSchedules() (schedules []*common.Schedule, modRevisions []int64, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		schedule 			*common.Schedule
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.ScheduleDir, clientv3.WithPrefix()); err != nil {
+		return
+	}
+	for _, kvPair = range getResp.Kvs {
+		schedule = &common.Schedule{}
+		if err = json.Unmarshal(kvPair.Value, schedule); err != nil {
+			logger.Logger.InfoLog("定时任务反序列化错误...已丢弃该错误:", err.Error())
+			continue
+		}
+		schedules = append(schedules, schedule)
+		modRevisions = append(modRevisions, kvPair.ModRevision)
+	}
+	return schedules, modRevisions, nil
+}
+
+// 以修改版本号做CAS修改定时任务，避免覆盖Leader同时写入的运行记录
+func (This *ScheduleManager) update(scheduleId int64, modify func(schedule *common.Schedule) error) (schedule *common.Schedule, err error) {
+	var (
+		modRevision 		int64
+		scheduleValue 		[]byte
+		txnResp 			*clientv3.TxnResponse
+		retry 				int
+	)
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if schedule, modRevision, err = This.getSchedule(scheduleId); err != nil {
+			return nil, err
+		}
+		if err = modify(schedule); err != nil {
+			return nil, err
+		}
+		if scheduleValue, err = json.Marshal(schedule); err != nil {
+			return nil, err
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(scheduleKey(scheduleId)), "=", modRevision)).Then(
+			clientv3.OpPut(scheduleKey(scheduleId), string(scheduleValue))).Commit(); err != nil {
+			return nil, err
+		}
+		if txnResp.Succeeded {
+			return schedule, nil
+		}
+	}
+	return nil, taskManager.ERROR_STATE_CONFLICT
+}
+
+// 暂停定时任务
+func (This *ScheduleManager) PauseSchedule(scheduleId int64) (schedule *common.Schedule, err error) {
+	return This.update(scheduleId, func(schedule *common.Schedule) error {
+		schedule.Paused = true
+		return nil
+	})
+}
+
+// 恢复定时任务，暂停期间错过的运行不再补上，从现在开始计算下一次的计划时间
+func (This *ScheduleManager) ResumeSchedule(scheduleId int64) (schedule *common.Schedule, err error) {
+	return This.update(scheduleId, func(schedule *common.Schedule) (err error) {
+		if schedule.NextTime, err = nextTime(schedule.CronExpr, time.Now()); err != nil {
+			return
+		}
+		schedule.Paused = false
+		return nil
+	})
+}
+
+// 生成一次运行：在同一个事务中写入任务并推进定时任务的计划时间，定时任务被并发修改(暂停、Leader切换)时放弃
+func (This *ScheduleManager) materialize(schedule *common.Schedule, modRevision int64, now time.Time) (err error) {
+	var (
+		task 				common.Task
+		ops 				[]clientv3.Op
+		scheduleValue 		[]byte
+		txnResp 			*clientv3.TxnResponse
+	)
+	task = *schedule.Template
+	task.TaskId = 0
+	task.TaskName = runTaskName(schedule.ScheduleId, schedule.RunCount)
+	task.ScheduleId = schedule.ScheduleId
+	task.ScheduleTime = schedule.NextTime
+	if ops, err = taskManager.TM.SaveTaskOps(&task); err != nil {
+		return
+	}
+
+	// master停机期间错过的多次运行只补一次
+	schedule.LastTime = schedule.NextTime
+	schedule.LastTaskId = task.TaskId
+	schedule.RunCount++
+	if schedule.NextTime, err = nextTime(schedule.CronExpr, now); err != nil {
+		// 不会再触发的定时任务暂停
+		schedule.Paused = true
+		schedule.NextTime = 0
+	}
+	if scheduleValue, err = json.Marshal(schedule); err != nil {
+		return
+	}
+	ops = append(ops, clientv3.OpPut(scheduleKey(schedule.ScheduleId), string(scheduleValue)))
+
+	if txnResp, err = This.kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.ModRevision(scheduleKey(schedule.ScheduleId)), "=", modRevision)).Then(
+		ops...).Commit(); err != nil {
+		return
+	}
+	if txnResp.Succeeded {
+		logger.Logger.InfoLog("定时任务生成任务:", task.TaskName, "task_id=", task.TaskId)
+	}
+	return nil
+}
+
+// 检查所有到期的定时任务
+func (This *ScheduleManager) checkAll() {
+	var (
+		schedules 			[]*common.Schedule
+		modRevisions 		[]int64
+		index 				int
+		schedule 			*common.Schedule
+		now 				time.Time
+		err 				error
+	)
+	if schedules, modRevisions, err = This.listSchedules(); err != nil {
+		logger.Logger.WarnLog("读取定时任务失败:", err)
+		return
+	}
+	now = time.Now()
+	for index, schedule = range schedules {
+		if schedule.Paused || schedule.NextTime == 0 || schedule.NextTime > now.UnixNano() / 1000 / 1000 {
+			continue
+		}
+		if err = This.materialize(schedule, modRevisions[index], now); err != nil {
+			logger.Logger.WarnLog("定时任务生成任务失败, schedule_id=", schedule.ScheduleId, "err=", err)
+		}
+	}
+}
+
+// 成为Leader后启动，ctx被取消(失去Leader)时退出
+func (This *ScheduleManager) Start(ctx context.Context) {
+	var (
+		ticker 				*time.Ticker
+	)
+	ticker = time.NewTicker(config.Cfg.ScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			This.checkAll()
+		}
+	}
+}
+
+// 定时任务管理器单例
+var (
+	SM 					*ScheduleManager
+)
+
+func InitScheduleManager() (err error) {
+	if SM == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		SM = &ScheduleManager{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package segmenter
+
+import (
+	"context"
+	"crack_front/src/config"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	ERROR_PROBE_FAILED			error = errors.New("无法获取视频时长或帧率")
+)
+
+// ffprobe的输出
+type probeOutput struct {
+	Streams 			[]struct {
+		RFrameRate 			string 		`json:"r_frame_rate"`
+	} 										`json:"streams"`
+	Format 				struct {
+		Duration 			string 		`json:"duration"`
+	} 										`json:"format"`
+}
+
+// 解析"30000/1001"形式的帧率
+func parseFrameRate(rate string) float64 {
+	var (
+		parts 				[]string
+		num 				float64
+		den 				float64
+		err 				error
+	)
+	if parts = strings.SplitN(rate, "/", 2); len(parts) != 2 {
+		num, _ = strconv.ParseFloat(rate, 64)
+		return num
+	}
+	if num, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return 0
+	}
+	if den, err = strconv.ParseFloat(parts[1], 64); err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// 用ffprobe获取视频的时长(s)和帧率，视频先写入临时文件(mp4的索引可能在文件末尾，无法从管道读取)
+// 畸形的文件可能使ffprobe长时间不退出，超过ProbeTimeout时杀死它
+func ProbeVideo(reader io.Reader) (duration float64, fps float64, err error) {
+	var (
+		ctx 				context.Context
+		cancel 				context.CancelFunc
+		tmpFile 			*os.File
+		output 				[]byte
+		probe 				probeOutput
+	)
+	if tmpFile, err = os.CreateTemp("", "crack_probe_*"); err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = io.Copy(tmpFile, reader)
+	_ = tmpFile.Close()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), config.Cfg.ProbeTimeout)
+	defer cancel()
+	if output, err = exec.CommandContext(ctx, config.Cfg.FfprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=r_frame_rate:format=duration",
+		"-of", "json",
+		tmpFile.Name()).Output(); err != nil {
+		return
+	}
+	if err = json.Unmarshal(output, &probe); err != nil {
+		return
+	}
+	if duration, err = strconv.ParseFloat(probe.Format.Duration, 64); err != nil || duration <= 0 {
+		return 0, 0, ERROR_PROBE_FAILED
+	}
+	// 合并分段结果时按帧率换算帧序号，没有帧率时不能切分
+	if len(probe.Streams) != 0 {
+		fps = parseFrameRate(probe.Streams[0].RFrameRate)
+	}
+	if fps <= 0 {
+		return 0, 0, ERROR_PROBE_FAILED
+	}
+	return duration, fps, nil
+}
@@ -0,0 +1,433 @@
+package segmenter
+
+import (
+	"context"
+	"crack_front/src/common"
+	"crack_front/src/config"
+	"crack_front/src/master/logManager"
+	"crack_front/src/master/logger"
+	"crack_front/src/master/taskManager"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 视频切分器。较长的视频按时间切分为多个分段子任务放入任务目录，由不同的worker并行执行
+// 切分计划保存在SegmentDir/视频任务id，Leader定期检查分段是否全部结束，全部成功后把各分段的结果合并为一条时间线
+
+type Segmenter struct {
+	client 				*clientv3.Client
+	kv 					clientv3.KV
+}
+
+// 切分计划在etcd中的key
+func planKey(taskId int64) string {
+	return path.Join(config.Cfg.SegmentDir, strconv.FormatInt(taskId, 10))
+}
+
+// 分段子任务名称
+func segmentTaskName(taskId int64, index int) string {
+	return "s" + strconv.FormatInt(taskId, 10) + "_" + strconv.Itoa(index)
+}
+
+// 视频是否需要切分。合并结果时按帧率换算各分段的帧序号，帧率未知时不切分
+func NeedSplit(duration float64, fps float64) bool {
+	return config.Cfg.SegmentLength > 0 && duration > config.Cfg.SegmentLength && fps > 0
+}
+
+// 把视频任务切分为分段子任务，视频任务本身只有状态记录，由master执行(直接以running状态创建，合并后结束)
+func (This *Segmenter) SplitTask(task *common.Task, duration float64, fps float64) (err error) {
+	var (
+		plan 				*common.SegmentPlan
+		planValue 			[]byte
+		segment 			*common.Task
+		count 				int
+		index 				int
+		ops 				[]clientv3.Op
+		segmentOps 			[]clientv3.Op
+		op 					clientv3.Op
+	)
+	if task.TaskId == 0 {
+		if task.TaskId, err = taskManager.TM.NewTaskId(); err != nil {
+			return
+		}
+	}
+
+	// 预先分配分段子任务id
+	count = int(math.Ceil(duration / config.Cfg.SegmentLength))
+	plan = &common.SegmentPlan{
+		Parent:   task,
+		Duration: duration,
+		Fps:      fps,
+		Segments: make([]*common.Task, 0, count),
+	}
+	for index = 0; index < count; index++ {
+		segment = &common.Task{
+			TaskType:     task.TaskType,
+			UserId:       task.UserId,
+			TaskName:     segmentTaskName(task.TaskId, index),
+			TaskTimeOut:  task.TaskTimeOut,
+			InputRef:     task.InputRef,
+			JobId:        task.JobId,
+			Priority:     task.Priority,
+			Selector:     task.Selector,
+			MaxRetries:   task.MaxRetries,
+			RetryBackoff: task.RetryBackoff,
+			NotBefore:    task.NotBefore,
+			Deadline:     task.Deadline,
+			ParentId:     task.TaskId,
+			SegmentIndex: index,
+			SegmentStart: float64(index) * config.Cfg.SegmentLength,
+			SegmentEnd:   float64(index + 1) * config.Cfg.SegmentLength,
+		}
+		// 最后一个分段一直执行到视频结尾，避免时长误差丢掉结尾的几帧
+		if index == count - 1 {
+			segment.SegmentEnd = 0
+		}
+		if segment.TaskId, err = taskManager.TM.NewTaskId(); err != nil {
+			return
+		}
+		plan.Segments = append(plan.Segments, segment)
+	}
+
+	// 视频任务的状态记录、所有分段和切分计划在同一个事务中写入，失败时什么都不留下
+	if op, err = taskManager.TM.MasterRunningStatusOp(task); err != nil {
+		return
+	}
+	ops = append(ops, op)
+	for _, segment = range plan.Segments {
+		if segmentOps, err = taskManager.TM.SaveTaskOps(segment); err != nil {
+			return
+		}
+		ops = append(ops, segmentOps...)
+	}
+	if planValue, err = json.Marshal(plan); err != nil {
+		return
+	}
+	ops = append(ops, clientv3.OpPut(planKey(task.TaskId), string(planValue)))
+	if err = taskManager.TM.CommitOps(ops); err != nil {
+		return
+	}
+	logger.Logger.InfoLog("视频任务切分为", count, "个分段, task_id=", task.TaskId)
+	return nil
+}
+
+// 把各分段的结果合并为视频任务的结果，帧序号和时间戳从相对于分段开始换算为相对于视频开始
+func mergeResults(plan *common.SegmentPlan, results []*common.CrackResult) (merged *common.CrackResult) {
+	var (
+		index 				int
+		result 				*common.CrackResult
+		frame 				*common.CrackFrame
+		segment 			*common.Task
+		frameOffset 		int64
+		modelVersions 		[]string
+		seen 				map[string]bool
+	)
+	merged = &common.CrackResult{
+		TaskType:   plan.Parent.TaskType,
+		UserId:     plan.Parent.UserId,
+		TaskName:   plan.Parent.TaskName,
+		TaskId:     plan.Parent.TaskId,
+		CreateTime: time.Now().UnixNano() / 1000 / 1000,
+	}
+	seen = make(map[string]bool)
+	for index, result = range results {
+		segment = plan.Segments[index]
+		frameOffset = int64(math.Round(segment.SegmentStart * plan.Fps))
+		for _, frame = range result.Frames {
+			frame.FrameIndex += frameOffset
+			frame.Timestamp += segment.SegmentStart
+			merged.Frames = append(merged.Frames, frame)
+		}
+		merged.Detections = append(merged.Detections, result.Detections...)
+		if !seen[result.ModelVersion] {
+			seen[result.ModelVersion] = true
+			modelVersions = append(modelVersions, result.ModelVersion)
+		}
+	}
+	sort.SliceStable(merged.Frames, func(i, j int) bool {
+		return merged.Frames[i].Timestamp < merged.Frames[j].Timestamp
+	})
+	merged.ModelVersion = strings.Join(modelVersions, ",")
+	merged.Summarize()
+	return
+}
+
+// 结束视频任务并删除切分计划
+func (This *Segmenter) finish(plan *common.SegmentPlan, to common.TaskState, taskErr string) (err error) {
+	var (
+		status 				*common.TaskStatus
+	)
+	if status, err = taskManager.TM.TransitionTaskStatus(plan.Parent.TaskId, to, taskErr); err != nil {
+		// 视频任务已经结束(被杀死等)，只删除切分计划
+		if err != taskManager.ERROR_STATE_TRANSITION {
+			return
+		}
+	} else if err = logManager.LM.SaveTaskStatus(status); err != nil {
+		logger.Logger.WarnLog("镜像视频任务状态失败, task_id=", plan.Parent.TaskId, "err=", err)
+	}
+	_, err = This.kv.Delete(context.TODO(), planKey(plan.Parent.TaskId))
+	return
+}
+
+// 停止还没有结束的分段：还没有被worker抢占的取消，已经开始执行的强杀
+func killSegments(plan *common.SegmentPlan, statuses []*common.TaskStatus) {
+	var (
+		index 				int
+		status 				*common.TaskStatus
+		err 				error
+	)
+	for index, status = range statuses {
+		if status.State.IsFinal() {
+			continue
+		}
+		if status.State == common.TaskPending {
+			if _, err = taskManager.TM.CancelTask(status.TaskId); err == nil {
+				continue
+			} else if err != taskManager.ERROR_TASK_STARTED && err != taskManager.ERROR_TASK_NOT_FOUND {
+				logger.Logger.WarnLog("取消视频分段失败, task_id=", status.TaskId, "err=", err)
+				continue
+			}
+		}
+		if err = taskManager.TM.KillTask(plan.Segments[index]); err != nil {
+			logger.Logger.WarnLog("强杀视频分段失败, task_id=", status.TaskId, "err=", err)
+		}
+	}
+}
+
+// 读取视频任务的切分计划，没有切分(或者已经结束)时返回nil
+func (This *Segmenter) getPlan(taskId int64) (plan *common.SegmentPlan, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+	)
+	if getResp, err = This.kv.Get(context.TODO(), planKey(taskId)); err != nil {
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, nil
+	}
+	plan = &common.SegmentPlan{}
+	if err = json.Unmarshal(getResp.Kvs[0].Value, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// 按任务名查找正在执行的切分计划。视频任务本身不在任务目录中，按名称强杀时由此找到它
+func (This *Segmenter) findPlan(taskType string, userId uint, taskName string) (plan *common.SegmentPlan, err error) {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.SegmentDir, clientv3.WithPrefix()); err != nil {
+		return
+	}
+	for _, kvPair = range getResp.Kvs {
+		plan = &common.SegmentPlan{}
+		if json.Unmarshal(kvPair.Value, plan) != nil {
+			continue
+		}
+		if plan.Parent.TaskType == taskType && plan.Parent.UserId == userId && plan.Parent.TaskName == taskName {
+			return plan, nil
+		}
+	}
+	return nil, nil
+}
+
+// 停止切分执行的视频任务：视频任务置为killed，并停止所有还没有结束的分段
+// 切分计划由Leader在下次检查时删除(同时再次停止分段，防止这里停止失败的分段继续执行)
+func (This *Segmenter) stop(plan *common.SegmentPlan, taskErr string) (status *common.TaskStatus, err error) {
+	var (
+		statuses 			[]*common.TaskStatus
+	)
+	if status, err = taskManager.TM.TransitionTaskStatus(plan.Parent.TaskId, common.TaskKilled, taskErr); err != nil {
+		return
+	}
+	if err = logManager.LM.SaveTaskStatus(status); err != nil {
+		logger.Logger.WarnLog("镜像视频任务状态失败, task_id=", plan.Parent.TaskId, "err=", err)
+	}
+	if statuses, err = taskManager.TM.LookupTaskStatuses(plan.Segments); err != nil {
+		return status, err
+	}
+	killSegments(plan, statuses)
+	logger.Logger.InfoLog("停止视频任务：", plan.Parent.TaskName)
+	return status, nil
+}
+
+// 按名称强杀切分执行的视频任务，不是切分执行的视频任务时found为false
+func (This *Segmenter) KillTask(task *common.Task) (found bool, err error) {
+	var (
+		plan 				*common.SegmentPlan
+	)
+	if plan, err = This.findPlan(task.TaskType, task.UserId, task.TaskName); err != nil || plan == nil {
+		return false, err
+	}
+	if _, err = This.stop(plan, "任务被强杀"); err == taskManager.ERROR_STATE_TRANSITION {
+		// 视频任务已经结束
+		err = nil
+	}
+	return true, err
+}
+
+// 取消切分执行的视频任务，不是切分执行的视频任务时found为false
+// 视频任务创建时就是running状态，取消时与强杀一样置为killed
+func (This *Segmenter) CancelTask(taskId int64) (status *common.TaskStatus, found bool, err error) {
+	var (
+		plan 				*common.SegmentPlan
+	)
+	if plan, err = This.getPlan(taskId); err != nil || plan == nil {
+		return nil, false, err
+	}
+	status, err = This.stop(plan, "任务被取消")
+	return status, true, err
+}
+
+// 检查一个切分计划：视频任务已经结束(被杀死等)或者有分段失败时杀死其余分段，全部成功时合并结果
+func (This *Segmenter) check(plan *common.SegmentPlan) (err error) {
+	var (
+		parent 				*common.TaskStatus
+		statuses 			[]*common.TaskStatus
+		status 				*common.TaskStatus
+		index 				int
+		results 			[]*common.CrackResult
+		result 				*common.CrackResult
+		failed 				*common.TaskStatus
+		failedIndex 		int
+		unfinished 			bool
+		lastFinish 			int64
+	)
+	if statuses, err = taskManager.TM.LookupTaskStatuses(plan.Segments); err != nil {
+		return
+	}
+	if parent, err = taskManager.TM.GetTaskStatus(plan.Parent.TaskId); err != nil && err != taskManager.ERROR_TASK_NOT_FOUND {
+		return
+	}
+	if parent == nil || parent.State.IsFinal() {
+		killSegments(plan, statuses)
+		_, err = This.kv.Delete(context.TODO(), planKey(plan.Parent.TaskId))
+		return
+	}
+
+	for index, status = range statuses {
+		if status.FinishTime > lastFinish {
+			lastFinish = status.FinishTime
+		}
+		switch status.State {
+		case common.TaskSucceeded:
+		case common.TaskFailed, common.TaskKilled, common.TaskTimedOut, common.TaskCancelled:
+			if failed == nil {
+				failed, failedIndex = status, index
+			}
+		default:
+			unfinished = true
+		}
+	}
+	// 还有分段没有结束
+	if failed == nil && unfinished {
+		return nil
+	}
+
+	if failed != nil {
+		killSegments(plan, statuses)
+		return This.finish(plan, common.TaskFailed, fmt.Sprintf("分段%d %s: %s", failedIndex, failed.State, failed.Error))
+	}
+
+	// 识别结果由worker异步落库，可能晚于状态，取不全时下次再合并。超过MergeTimeout还取不到(如模型程序不输出结果)时视频任务失败
+	results = make([]*common.CrackResult, 0, len(plan.Segments))
+	for index = range plan.Segments {
+		if result, err = logManager.LM.QueryTaskResult(plan.Segments[index].TaskId, plan.Segments[index].UserId); err != nil {
+			if time.Now().UnixNano() / 1000 / 1000 - lastFinish > config.Cfg.MergeTimeout.Milliseconds() {
+				return This.finish(plan, common.TaskFailed, fmt.Sprintf("分段%d的识别结果缺失", index))
+			}
+			return nil
+		}
+		results = append(results, result)
+	}
+	if err = logManager.LM.SaveTaskResult(mergeResults(plan, results)); err != nil {
+		return
+	}
+	logger.Logger.InfoLog("视频分段结果合并完成, task_id=", plan.Parent.TaskId)
+	return This.finish(plan, common.TaskSucceeded, "")
+}
+
+// 检查所有切分计划
+func (This *Segmenter) checkAll() {
+	var (
+		getResp 			*clientv3.GetResponse
+		kvPair 				*mvccpb.KeyValue
+		plan 				*common.SegmentPlan
+		err 				error
+	)
+	if getResp, err = This.kv.Get(context.TODO(), config.Cfg.SegmentDir, clientv3.WithPrefix()); err != nil {
+		logger.Logger.WarnLog("读取视频切分计划失败:", err)
+		return
+	}
+	for _, kvPair = range getResp.Kvs {
+		plan = &common.SegmentPlan{}
+		if err = json.Unmarshal(kvPair.Value, plan); err != nil {
+			logger.Logger.InfoLog("视频切分计划反序列化错误...已丢弃该错误:", err.Error())
+			continue
+		}
+		if err = This.check(plan); err != nil {
+			logger.Logger.WarnLog("检查视频切分计划失败, task_id=", plan.Parent.TaskId, "err=", err)
+		}
+	}
+}
+
+// 成为Leader后启动，ctx被取消(失去Leader)时退出
+func (This *Segmenter) Start(ctx context.Context) {
+	var (
+		ticker 				*time.Ticker
+	)
+	ticker = time.NewTicker(config.Cfg.MergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			This.checkAll()
+		}
+	}
+}
+
+// 视频切分器单例
+var (
+	VS 					*Segmenter
+)
+
+func InitSegmenter() (err error) {
+	if VS == nil {
+		var (
+			etcdConfig 			clientv3.Config
+			client 				*clientv3.Client
+		)
+		etcdConfig = clientv3.Config{
+			Endpoints:   config.Cfg.Endpoints,
+			DialTimeout: config.Cfg.DialTimeout,
+			DialOptions:  []grpc.DialOption{
+				grpc.WithBlock(),
+			},
+		}
+
+		// 建立连接
+		if client, err = clientv3.New(etcdConfig); err != nil {
+			return err
+		}
+
+		// 赋值单例
+		VS = &Segmenter{
+			client: client,
+			kv:     clientv3.NewKV(client),
+		}
+	}
+	return nil
+}
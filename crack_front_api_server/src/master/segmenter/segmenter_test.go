@@ -0,0 +1,96 @@
+package segmenter
+
+import (
+	"crack_front/src/common"
+	"math"
+	"testing"
+)
+
+// 帧序号和时间戳从相对于分段开始换算为相对于视频开始，合并后按时间排序并重新统计
+func TestMergeResults(t *testing.T) {
+	var (
+		plan 				*common.SegmentPlan
+		results 			[]*common.CrackResult
+		merged 				*common.CrackResult
+		wantIndexes 		[]int64
+		wantTimestamps 		[]float64
+		index 				int
+	)
+	plan = &common.SegmentPlan{
+		Parent: &common.Task{TaskType: "video", UserId: 7, TaskName: "v", TaskId: 100},
+		Fps:    25,
+		Segments: []*common.Task{
+			{TaskId: 101, SegmentStart: 0, SegmentEnd: 120},
+			{TaskId: 102, SegmentStart: 120, SegmentEnd: 240},
+			{TaskId: 103, SegmentStart: 240, SegmentEnd: 0},
+		},
+	}
+	results = []*common.CrackResult{
+		{
+			ModelVersion: "v1",
+			Frames: []*common.CrackFrame{
+				{FrameIndex: 50, Timestamp: 2, Detections: []*common.CrackDetection{{Length: 3, Width: 0.5}}},
+			},
+		},
+		{
+			ModelVersion: "v1",
+			Frames: []*common.CrackFrame{
+				// 分段内的结果没有按时间排序
+				{FrameIndex: 25, Timestamp: 1, Detections: []*common.CrackDetection{{Length: 9, Width: 0.2}}},
+				{FrameIndex: 0, Timestamp: 0, Detections: []*common.CrackDetection{{Length: 1, Width: 1.5}}},
+			},
+		},
+		{
+			ModelVersion: "v2",
+			Frames: []*common.CrackFrame{
+				{FrameIndex: 10, Timestamp: 0.4},
+			},
+		},
+	}
+
+	merged = mergeResults(plan, results)
+	if merged.TaskId != 100 || merged.UserId != 7 || merged.TaskName != "v" || merged.TaskType != "video" {
+		t.Errorf("merged task = %d %d %s %s", merged.TaskId, merged.UserId, merged.TaskName, merged.TaskType)
+	}
+	wantIndexes = []int64{50, 3000, 3025, 6010}
+	wantTimestamps = []float64{2, 120, 121, 240.4}
+	if len(merged.Frames) != len(wantIndexes) {
+		t.Fatalf("len(Frames) = %d, want %d", len(merged.Frames), len(wantIndexes))
+	}
+	for index = range wantIndexes {
+		if merged.Frames[index].FrameIndex != wantIndexes[index] || math.Abs(merged.Frames[index].Timestamp - wantTimestamps[index]) > 1e-9 {
+			t.Errorf("Frames[%d] = %d@%v, want %d@%v", index, merged.Frames[index].FrameIndex, merged.Frames[index].Timestamp,
+				wantIndexes[index], wantTimestamps[index])
+		}
+	}
+	if merged.ModelVersion != "v1,v2" {
+		t.Errorf("ModelVersion = %q, want \"v1,v2\"", merged.ModelVersion)
+	}
+	if merged.CrackCount != 3 || merged.MaxLength != 9 || merged.MaxWidth != 1.5 {
+		t.Errorf("summary = %d %v %v, want 3 9 1.5", merged.CrackCount, merged.MaxLength, merged.MaxWidth)
+	}
+}
+
+// 帧率不是整数时分段的起始帧四舍五入
+func TestMergeResultsFractionalFps(t *testing.T) {
+	var (
+		plan 				*common.SegmentPlan
+		merged 				*common.CrackResult
+	)
+	plan = &common.SegmentPlan{
+		Parent: &common.Task{TaskId: 200},
+		Fps:    29.97,
+		Segments: []*common.Task{
+			{TaskId: 201, SegmentStart: 0},
+			{TaskId: 202, SegmentStart: 60},
+		},
+	}
+	merged = mergeResults(plan, []*common.CrackResult{
+		{},
+		{Frames: []*common.CrackFrame{{FrameIndex: 1, Timestamp: 0.03}}},
+	})
+	// 60 * 29.97 = 1798.2，起始帧为1798，分段内的第1帧为1799
+	if len(merged.Frames) != 1 || merged.Frames[0].FrameIndex != 1799 {
+		t.Errorf("Frames = %+v, want frame 1799", merged.Frames)
+	}
+}
@@ -4,15 +4,39 @@ import (
 	"context"
 	"crack_front/src/common"
 	"crack_front/src/config"
+	"crack_front/src/master/logManager"
 	"crack_front/src/master/logger"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 	"path"
 	"strconv"
+	"time"
 )
 
+var (
+	ERROR_TASK_NOT_FOUND		error = errors.New("任务不存在")
+	ERROR_STATE_TRANSITION		error = errors.New("非法的任务状态转换")
+	ERROR_STATE_CONFLICT		error = errors.New("任务状态被并发修改")
+	ERROR_TASK_STARTED			error = errors.New("任务已经被worker抢占，不能取消")
+	ERROR_TOO_MANY_OPS			error = errors.New("一次提交的任务过多")
+	ERROR_TASK_EXPIRED			error = errors.New("该任务在最晚开始时间之前没有开始执行，已过期")
+)
+
+// etcd一个事务中最多的操作数
+const maxTxnOps = 128
+
+// CAS冲突时的最大重试次数
+const maxCasRetry = 5
+
+// 由master自己执行的任务(如被切分的视频任务)状态记录中的执行者
+const masterWorker = "master"
+
 // 一个etcd客户端，用来管理任务
 type TaskManager struct {
 	client 		*clientv3.Client
@@ -20,107 +44,606 @@ type TaskManager struct {
 	lease 		clientv3.Lease
 }
 
+// 生成全局唯一且递增的任务id(etcd的revision)
+func (This *TaskManager) NewTaskId() (taskId int64, err error) {
+	var (
+		Op 				clientv3.Op
+		OpResp			clientv3.OpResponse
+	)
+	Op = clientv3.OpPut(config.Cfg.TaskIdKey, "")
+	if OpResp, err = This.kv.Do(context.TODO(), Op); err != nil{
+		return
+	}
+	return OpResp.Put().Header.Revision, nil
+}
+
+// 任务状态在etcd中的key
+func statusKey(taskId int64) string {
+	return path.Join(config.Cfg.StatusDir, strconv.FormatInt(taskId, 10))
+}
+
+// 新任务的pending状态记录
+func newPendingStatus(task *common.Task) (statusValue []byte, err error) {
+	var(
+		now				int64
+	)
+	now = time.Now().UnixNano() / 1000 / 1000
+	return json.Marshal(&common.TaskStatus{
+		TaskType:   task.TaskType,
+		UserId:     task.UserId,
+		TaskName:   task.TaskName,
+		TaskId:     task.TaskId,
+		State:      common.TaskPending,
+		SubmitTime: now,
+		UpdateTime: now,
+	})
+}
+
 func (This *TaskManager) SaveTask(task *common.Task) (err error) {
-	// 将该任务保存到/crack/task/目录下
+	// 将该任务保存到/crack/task/目录下，同时创建pending状态的任务状态记录
+	var(
+		ops				[]clientv3.Op
+	)
+	if ops, err = This.SaveTaskOps(task); err != nil{
+		return
+	}
+
+	// 在同一个事务中写入，worker看到任务时状态记录一定已经存在
+	_, err = This.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return
+}
+
+// 保存任务需要的etcd操作(状态记录 + 任务)，供需要和其他操作放在同一个事务中的调用者使用
+func (This *TaskManager) SaveTaskOps(task *common.Task) (ops []clientv3.Op, err error) {
+	var(
+		statusOp		clientv3.Op
+		taskOp			clientv3.Op
+	)
+	// 为新任务分配id
+	if task.TaskId == 0 {
+		if task.TaskId, err = This.NewTaskId(); err != nil{
+			return
+		}
+	}
+
+	if statusOp, err = This.PendingStatusOp(task); err != nil{
+		return
+	}
+	if taskOp, err = This.PutTaskOp(task); err != nil{
+		return
+	}
+	return []clientv3.Op{statusOp, taskOp}, nil
+}
+
+// 创建pending状态记录的etcd操作
+func (This *TaskManager) PendingStatusOp(task *common.Task) (op clientv3.Op, err error) {
+	var(
+		statusValue		[]byte
+	)
+	if statusValue, err = newPendingStatus(task); err != nil{
+		return
+	}
+	return clientv3.OpPut(statusKey(task.TaskId), string(statusValue)), nil
+}
+
+// 把任务放入任务目录的etcd操作(状态记录已经存在时，如流水线中被放行的步骤)
+func (This *TaskManager) PutTaskOp(task *common.Task) (op clientv3.Op, err error) {
 	var(
 		taskKey			string
 		taskValue		[]byte
-		Op 				clientv3.Op
 	)
 	taskKey = path.Join(path.Join(path.Join(config.Cfg.TaskDir, task.TaskType), strconv.Itoa(int(task.UserId))), task.TaskName)
 
 	if taskValue, err = json.Marshal(task); err != nil{
 		return
 	}
+	return clientv3.OpPut(taskKey, string(taskValue), clientv3.WithPrevKV()), nil
+}
 
-	Op = clientv3.OpPut(taskKey, string(taskValue), clientv3.WithPrevKV())
-	_, err = This.kv.Do(context.TODO(), Op)
+// 只创建pending状态的任务状态记录，不放入任务目录(由master自己执行的任务)
+func (This *TaskManager) SaveTaskStatus(task *common.Task) (err error) {
+	var(
+		statusOp		clientv3.Op
+	)
+	if task.TaskId == 0 {
+		if task.TaskId, err = This.NewTaskId(); err != nil{
+			return
+		}
+	}
+	if statusOp, err = This.PendingStatusOp(task); err != nil{
+		return
+	}
+	_, err = This.kv.Do(context.TODO(), statusOp)
 	return
 }
 
-func (This *TaskManager) RemoveTask(task *common.Task) (oldTask *common.Task, err error) {
-	// 从/crack/task/中删除该任务
+// 创建由master自己执行的任务的running状态记录的etcd操作(跳过pending和claimed，与其他操作在同一个事务中写入)
+func (This *TaskManager) MasterRunningStatusOp(task *common.Task) (op clientv3.Op, err error) {
+	var(
+		now				int64
+		statusValue		[]byte
+	)
+	now = time.Now().UnixNano() / 1000 / 1000
+	if statusValue, err = json.Marshal(&common.TaskStatus{
+		TaskType:   task.TaskType,
+		UserId:     task.UserId,
+		TaskName:   task.TaskName,
+		TaskId:     task.TaskId,
+		State:      common.TaskRunning,
+		Worker:     masterWorker,
+		SubmitTime: now,
+		ClaimTime:  now,
+		StartTime:  now,
+		UpdateTime: now,
+	}); err != nil{
+		return
+	}
+	return clientv3.OpPut(statusKey(task.TaskId), string(statusValue)), nil
+}
+
+// 在一个事务中原子地执行ops。etcd限制一个事务(以及其中每个嵌套的事务)的操作数，
+// 操作较多时按maxTxnOps分组为嵌套的事务
+func (This *TaskManager) CommitOps(ops []clientv3.Op) (err error) {
 	var (
-		taskKey string
-		Op      clientv3.Op
-		OpResp  clientv3.OpResponse
-		temp    common.Task
+		groups			[]clientv3.Op
+		start			int
+		end				int
+	)
+	if len(ops) > maxTxnOps {
+		for start = 0; start < len(ops); start += maxTxnOps {
+			end = start + maxTxnOps
+			if end > len(ops) {
+				end = len(ops)
+			}
+			groups = append(groups, clientv3.OpTxn(nil, ops[start:end], nil))
+		}
+		ops = groups
+	}
+	if len(ops) > maxTxnOps {
+		return ERROR_TOO_MANY_OPS
+	}
+	_, err = This.kv.Txn(context.TODO()).Then(ops...).Commit()
+	return
+}
+
+// 把由master自己执行的任务的状态转换为to，与worker一样经过状态机校验并以修改版本号做CAS
+func (This *TaskManager) TransitionTaskStatus(taskId int64, to common.TaskState, taskErr string) (status *common.TaskStatus, err error) {
+	var (
+		statusValue		[]byte
+		txnResp			*clientv3.TxnResponse
+		retry			int
+		now				int64
 	)
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, err = This.GetTaskStatus(taskId); err != nil{
+			return nil, err
+		}
+		if !status.State.CanTransitionTo(to) {
+			return nil, ERROR_STATE_TRANSITION
+		}
 
-	// 该任务在etcd中的key
-	taskKey = path.Join(path.Join(path.Join(config.Cfg.TaskDir, task.TaskType), strconv.Itoa(int(task.UserId))), task.TaskName)
+		now = time.Now().UnixNano() / 1000 / 1000
+		status.State = to
+		status.UpdateTime = now
+		if taskErr != "" {
+			status.Error = taskErr
+		}
+		switch to {
+		case common.TaskClaimed:
+			status.ClaimTime = now
+			status.Worker = masterWorker
+		case common.TaskRunning:
+			status.StartTime = now
+		case common.TaskSucceeded, common.TaskFailed, common.TaskKilled, common.TaskTimedOut, common.TaskCancelled:
+			status.FinishTime = now
+		}
 
-	Op = clientv3.OpDelete(taskKey, clientv3.WithPrevKV())
+		if statusValue, err = json.Marshal(status); err != nil{
+			return nil, err
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(taskId)), "=", status.Revision)).Then(
+			clientv3.OpPut(statusKey(taskId), string(statusValue))).Commit(); err != nil{
+			return nil, err
+		}
+		if txnResp.Succeeded {
+			status.Revision = txnResp.Header.Revision
+			return status, nil
+		}
+	}
+	return nil, ERROR_STATE_CONFLICT
+}
+
+// 取消还没有被worker抢占的任务：在同一个事务中把状态置为cancelled并删除任务目录中的任务(没有被同名任务覆盖时)
+// 以状态记录的修改版本号做CAS，worker同时抢占时只有一方成功
+func (This *TaskManager) CancelTask(taskId int64) (status *common.TaskStatus, err error) {
+	var (
+		taskKey			string
+		statusValue		[]byte
+		txnResp			*clientv3.TxnResponse
+		retry			int
+		now				int64
+		getResp			*clientv3.GetResponse
+		task			*common.Task
+		deleteOps		[]clientv3.Op
+	)
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, err = This.GetTaskStatus(taskId); err != nil{
+			return nil, err
+		}
+		if !status.State.CanTransitionTo(common.TaskCancelled) {
+			return status, ERROR_TASK_STARTED
+		}
+		taskKey = path.Join(path.Join(path.Join(config.Cfg.TaskDir, status.TaskType), strconv.Itoa(int(status.UserId))), status.TaskName)
+
+		// 任务目录以任务名为key，用户可能已经用同名任务覆盖了它，只删除仍然是本任务的记录
+		if getResp, err = This.kv.Get(context.TODO(), taskKey); err != nil{
+			return nil, err
+		}
+		deleteOps = nil
+		if len(getResp.Kvs) != 0 {
+			task = &common.Task{}
+			if json.Unmarshal(getResp.Kvs[0].Value, task) == nil && task.TaskId == taskId {
+				deleteOps = []clientv3.Op{clientv3.OpTxn(
+					[]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(taskKey), "=", getResp.Kvs[0].ModRevision)},
+					[]clientv3.Op{clientv3.OpDelete(taskKey)},
+					nil)}
+			}
+		}
+
+		now = time.Now().UnixNano() / 1000 / 1000
+		status.State = common.TaskCancelled
+		status.Error = "任务在开始执行之前被取消"
+		status.UpdateTime = now
+		status.FinishTime = now
+		if statusValue, err = json.Marshal(status); err != nil{
+			return nil, err
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(taskId)), "=", status.Revision)).Then(
+			append([]clientv3.Op{clientv3.OpPut(statusKey(taskId), string(statusValue))}, deleteOps...)...).Commit(); err != nil{
+			return nil, err
+		}
+		if txnResp.Succeeded {
+			status.Revision = txnResp.Header.Revision
+			if err = logManager.LM.SaveTaskStatus(status); err != nil{
+				logger.Logger.WarnLog("镜像任务状态失败, task_id=", taskId, "err=", err)
+			}
+			logger.Logger.InfoLog("取消任务：", status.TaskName)
+			return status, nil
+		}
+	}
+	return nil, ERROR_STATE_CONFLICT
+}
+
+// 过期失败一个还没有被worker抢占的任务：在同一个事务中把状态从pending直接置为failed，通知任务失败(往fail目录下插入key)并报警
+// 以状态记录的修改版本号做CAS，worker同时抢占时只有一方成功。taskValue为任务目录中的任务
+func (This *TaskManager) ExpireTask(task *common.Task, taskValue []byte) (status *common.TaskStatus, err error) {
+	var (
+		failKey			string
+		warnKey			string
+		statusValue		[]byte
+		warnValue		[]byte
+		leaseGrantResp	*clientv3.LeaseGrantResponse
+		txnResp			*clientv3.TxnResponse
+		retry			int
+		now				int64
+	)
+	failKey = path.Join(config.Cfg.FailDir, task.TaskType, strconv.Itoa(int(task.UserId)), task.TaskName)
+	warnKey = path.Join(config.Cfg.WarnDir, task.TaskType, strconv.Itoa(int(task.UserId)), task.TaskName)
+	now = time.Now().UnixNano() / 1000 / 1000
+	// 与worker报警的内容一致，模型程序没有启动
+	if warnValue, err = json.Marshal(&common.WarnMessage{
+		TaskType:     task.TaskType,
+		UserId:       task.UserId,
+		TaskName:     task.TaskName,
+		TaskId:       task.TaskId,
+		Message:      ERROR_TASK_EXPIRED.Error(),
+		ErrorClass:   "expired",			// 与worker的错误类别一致
+		ExitCode:     -1,
+		GenerateTime: time.Now().UnixNano(),
+	}); err != nil{
+		return nil, err
+	}
+	// 报警信息与worker放置的一样在1s后删除
+	if leaseGrantResp, err = This.lease.Grant(context.TODO(), 1); err != nil{
+		return nil, err
+	}
+
+	for retry = 0; retry < maxCasRetry; retry++ {
+		if status, err = This.GetTaskStatus(task.TaskId); err != nil{
+			return nil, err
+		}
+		if status.State != common.TaskPending {
+			return status, ERROR_STATE_TRANSITION
+		}
+
+		status.State = common.TaskFailed
+		status.Error = ERROR_TASK_EXPIRED.Error()
+		status.UpdateTime = now
+		status.FinishTime = now
+		if statusValue, err = json.Marshal(status); err != nil{
+			return nil, err
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).If(
+			clientv3.Compare(clientv3.ModRevision(statusKey(task.TaskId)), "=", status.Revision)).Then(
+			clientv3.OpPut(statusKey(task.TaskId), string(statusValue)),
+			clientv3.OpPut(failKey, string(taskValue)),
+			clientv3.OpPut(warnKey, string(warnValue), clientv3.WithLease(leaseGrantResp.ID))).Commit(); err != nil{
+			return nil, err
+		}
+		if txnResp.Succeeded {
+			status.Revision = txnResp.Header.Revision
+			return status, nil
+		}
+	}
+	return nil, ERROR_STATE_CONFLICT
+}
+
+// Idempotency-Key在etcd中的key，key由客户端给出，取摘要避免其中的/等字符
+func idempotencyKey(userId uint, key string) string {
+	var (
+		sum 			[sha256.Size]byte
+	)
+	sum = sha256.Sum256([]byte(key))
+	return path.Join(config.Cfg.IdempotencyDir, strconv.Itoa(int(userId)), hex.EncodeToString(sum[:]))
+}
+
+// 占用Idempotency-Key，保留期内有效。该key已经被占用时返回第一次提交的任务id，否则返回0
+func (This *TaskManager) ReserveIdempotencyKey(userId uint, key string, taskId int64) (firstTaskId int64, err error) {
+	var (
+		leaseGrantResp	*clientv3.LeaseGrantResponse
+		txnResp			*clientv3.TxnResponse
+		rangeResp		*etcdserverpb.RangeResponse
+	)
+	if leaseGrantResp, err = This.lease.Grant(context.TODO(), int64(config.Cfg.IdempotencyRetention / time.Second)); err != nil{
+		return
+	}
+
+	// key不存在时写入，已存在时读出第一次提交的任务id
+	if txnResp, err = This.kv.Txn(context.TODO()).If(
+		clientv3.Compare(clientv3.CreateRevision(idempotencyKey(userId, key)), "=", 0)).Then(
+		clientv3.OpPut(idempotencyKey(userId, key), strconv.FormatInt(taskId, 10), clientv3.WithLease(leaseGrantResp.ID))).Else(
+		clientv3.OpGet(idempotencyKey(userId, key))).Commit(); err != nil{
+		return
+	}
+	if txnResp.Succeeded {
+		return 0, nil
+	}
+
+	_, _ = This.lease.Revoke(context.TODO(), leaseGrantResp.ID)
+	if rangeResp = txnResp.Responses[0].GetResponseRange(); len(rangeResp.Kvs) == 0 {
+		// 刚好过期，视为冲突让客户端重试
+		return 0, ERROR_STATE_CONFLICT
+	}
+	return strconv.ParseInt(string(rangeResp.Kvs[0].Value), 10, 64)
+}
+
+// 提交失败时释放Idempotency-Key，客户端可以用同一个key重试
+func (This *TaskManager) ReleaseIdempotencyKey(userId uint, key string) {
+	var (
+		err 			error
+	)
+	if _, err = This.kv.Delete(context.TODO(), idempotencyKey(userId, key)); err != nil{
+		logger.Logger.WarnLog("释放Idempotency-Key失败:", err)
+	}
+}
+
+// 查询etcd中的任务状态，任务不存在时返回ERROR_TASK_NOT_FOUND
+func (This *TaskManager) GetTaskStatus(taskId int64) (status *common.TaskStatus, err error) {
+	var (
+		Op 				clientv3.Op
+		OpResp			clientv3.OpResponse
+	)
+	Op = clientv3.OpGet(statusKey(taskId))
 	if OpResp, err = This.kv.Do(context.TODO(), Op); err != nil{
 		return
 	}
+	if len(OpResp.Get().Kvs) == 0 {
+		return nil, ERROR_TASK_NOT_FOUND
+	}
 
-	// 如果删除成功, 反序列化原来的任务
-	if len(OpResp.Del().PrevKvs) != 0{
-		if err = json.Unmarshal(OpResp.Del().PrevKvs[0].Value, &temp); err != nil{
-			logger.Logger.InfoLog("RemoveTask反序列化错误...已丢弃该错误:", err.Error())
-			return nil, nil
-		}
-		oldTask = &temp
+	status = &common.TaskStatus{}
+	if err = json.Unmarshal(OpResp.Get().Kvs[0].Value, status); err != nil{
+		return nil, err
 	}
+	status.Revision = OpResp.Get().Kvs[0].ModRevision
 	return
 }
 
-// watch某个目录的eventType事件
-func (This *TaskManager) WatchDir (dir string, eventType mvccpb.Event_EventType, notifyChan chan<- struct{}, errChan chan<- error)  {
+// 任务实时输出在etcd中的key
+func streamKey(taskId int64) string {
+	return path.Join(config.Cfg.StreamDir, strconv.FormatInt(taskId, 10))
+}
+
+// 在一个事务中读取任务的实时输出和etcd中的状态(没有时为nil)，返回读取时的版本号
+func (This *TaskManager) GetTaskStream(taskId int64) (stream *common.TaskStream, status *common.TaskStatus, revision int64, err error) {
 	var (
-		err							error
-		op							clientv3.Op
-		opResp						clientv3.OpResponse
-		watchVersion				int64
-		watchChan					clientv3.WatchChan
-		watchResp					clientv3.WatchResponse
-		event						*clientv3.Event
+		txnResp			*clientv3.TxnResponse
+		kvs 			[]*mvccpb.KeyValue
 	)
-
-	op = clientv3.OpGet(dir, clientv3.WithPrefix())
-	if opResp, err = This.kv.Do(context.TODO(), op); err != nil {
-		errChan <- err
+	if txnResp, err = This.kv.Txn(context.TODO()).Then(
+		clientv3.OpGet(streamKey(taskId)),
+		clientv3.OpGet(statusKey(taskId))).Commit(); err != nil{
 		return
 	}
+	if kvs = txnResp.Responses[0].GetResponseRange().Kvs; len(kvs) != 0 {
+		stream = &common.TaskStream{}
+		if err = json.Unmarshal(kvs[0].Value, stream); err != nil{
+			return
+		}
+	}
+	if kvs = txnResp.Responses[1].GetResponseRange().Kvs; len(kvs) != 0 {
+		status = &common.TaskStatus{}
+		if err = json.Unmarshal(kvs[0].Value, status); err != nil{
+			return
+		}
+		status.Revision = kvs[0].ModRevision
+	}
+	return stream, status, txnResp.Header.Revision, nil
+}
+
+// 从revision之后监听任务的实时输出和状态的更新(删除事件忽略)，ctx被取消时两个通道都被关闭
+func (This *TaskManager) WatchTaskStream(ctx context.Context, taskId int64, revision int64) (streamChan chan *common.TaskStream, statusChan chan *common.TaskStatus) {
+	var (
+		watchStream 		clientv3.WatchChan
+		watchStatus 		clientv3.WatchChan
+	)
+	watchStream = This.client.Watch(ctx, streamKey(taskId), clientv3.WithRev(revision + 1))
+	watchStatus = This.client.Watch(ctx, statusKey(taskId), clientv3.WithRev(revision + 1))
+	streamChan = make(chan *common.TaskStream, 16)
+	statusChan = make(chan *common.TaskStatus, 4)
+
+	go func() {
+		var (
+			watchResp 		clientv3.WatchResponse
+			watchEvent 		*clientv3.Event
+			stream 			*common.TaskStream
+		)
+		defer close(streamChan)
+		for watchResp = range watchStream {
+			for _, watchEvent = range watchResp.Events {
+				stream = &common.TaskStream{}
+				if watchEvent.Type != clientv3.EventTypePut || json.Unmarshal(watchEvent.Kv.Value, stream) != nil {
+					continue
+				}
+				select {
+				case streamChan <- stream:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		var (
+			watchResp 		clientv3.WatchResponse
+			watchEvent 		*clientv3.Event
+			status 			*common.TaskStatus
+		)
+		defer close(statusChan)
+		for watchResp = range watchStatus {
+			for _, watchEvent = range watchResp.Events {
+				status = &common.TaskStatus{}
+				if watchEvent.Type != clientv3.EventTypePut || json.Unmarshal(watchEvent.Kv.Value, status) != nil {
+					continue
+				}
+				status.Revision = watchEvent.Kv.ModRevision
+				select {
+				case statusChan <- status:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return
+}
 
-	watchVersion = opResp.Get().Header.Revision+1
-	watchChan = clientv3.NewWatcher(This.client).Watch(context.TODO(), dir, clientv3.WithPrefix(), clientv3.WithRev(watchVersion))
+// 批量查询etcd中的任务状态，etcd中没有的任务不在返回的map中
+func (This *TaskManager) GetTaskStatuses(taskIds []int64) (statuses map[int64]*common.TaskStatus, err error) {
+	var (
+		ops 			[]clientv3.Op
+		txnResp			*clientv3.TxnResponse
+		opResp			*etcdserverpb.ResponseOp
+		kvPair			*mvccpb.KeyValue
+		status			*common.TaskStatus
+		start			int
+		end				int
+		taskId			int64
+	)
+	statuses = make(map[int64]*common.TaskStatus, len(taskIds))
 
-	// 遍历watch的事件, for退出说明watchChan关闭
-	for watchResp = range watchChan{
-		for _, event = range watchResp.Events{
-			switch event.Type {
-			case eventType:					// 想要的事件发生
-				// 通知loop协程
-				notifyChan <- struct{}{}
-			// 不在乎其他事件
+	// 一个事务中的操作数有上限，分批查询
+	for start = 0; start < len(taskIds); start += maxTxnOps {
+		end = start + maxTxnOps
+		if end > len(taskIds) {
+			end = len(taskIds)
+		}
+		ops = make([]clientv3.Op, 0, end - start)
+		for _, taskId = range taskIds[start:end] {
+			ops = append(ops, clientv3.OpGet(statusKey(taskId)))
+		}
+		if txnResp, err = This.kv.Txn(context.TODO()).Then(ops...).Commit(); err != nil{
+			return
+		}
+		for _, opResp = range txnResp.Responses {
+			for _, kvPair = range opResp.GetResponseRange().Kvs {
+				status = &common.TaskStatus{}
+				if err = json.Unmarshal(kvPair.Value, status); err != nil{
+					logger.Logger.InfoLog("任务状态反序列化错误...已丢弃该错误:", err.Error())
+					continue
+				}
+				status.Revision = kvPair.ModRevision
+				statuses[status.TaskId] = status
 			}
 		}
 	}
-	// 期待事件没有发生，watchChan就已经被关闭了 TODO
+	return statuses, nil
+}
 
+// 按顺序查询一组任务的状态，etcd中没有时查MongoDB中的镜像，都没有时视为pending
+func (This *TaskManager) LookupTaskStatuses(tasks []*common.Task) (statuses []*common.TaskStatus, err error) {
+	var (
+		taskIds 		[]int64
+		etcdStatuses 	map[int64]*common.TaskStatus
+		status 			*common.TaskStatus
+		task 			*common.Task
+		ok 				bool
+	)
+	taskIds = make([]int64, 0, len(tasks))
+	for _, task = range tasks {
+		taskIds = append(taskIds, task.TaskId)
+	}
+	if etcdStatuses, err = This.GetTaskStatuses(taskIds); err != nil{
+		return
+	}
 
+	statuses = make([]*common.TaskStatus, 0, len(tasks))
+	for _, task = range tasks {
+		if status, ok = etcdStatuses[task.TaskId]; !ok {
+			if status, err = logManager.LM.QueryTaskStatus(task.TaskId); err != nil {
+				status = &common.TaskStatus{
+					TaskType: task.TaskType,
+					UserId:   task.UserId,
+					TaskName: task.TaskName,
+					TaskId:   task.TaskId,
+					State:    common.TaskPending,
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
 }
-// 返回两个chan通知任务成功或者失败
-func (This *TaskManager) WatchTask (task *common.Task) (finishChan <-chan struct{}, failChan <-chan struct{}, errChan <-chan error) {
+
+func (This *TaskManager) RemoveTask(task *common.Task) (oldTask *common.Task, err error) {
+	// 从/crack/task/中删除该任务
 	var (
-		finishDir					string
-		failDir						string
-		finishChanInternal			= make(chan struct{}, 1)
-		failChanInternal			= make(chan struct{}, 1)
-		errChanInternal				= make(chan error, 2)
+		taskKey string
+		Op      clientv3.Op
+		OpResp  clientv3.OpResponse
+		temp    common.Task
 	)
 
-	// 路径
-	finishDir = path.Join(path.Join(config.Cfg.FinishDir, task.TaskType), strconv.Itoa(int(task.UserId)))
-	failDir = path.Join(path.Join(config.Cfg.FailDir, task.TaskType), strconv.Itoa(int(task.UserId)))
+	// 该任务在etcd中的key
+	taskKey = path.Join(path.Join(path.Join(config.Cfg.TaskDir, task.TaskType), strconv.Itoa(int(task.UserId))), task.TaskName)
 
-	// 两个协程去watch这两个目录
-	go This.WatchDir(finishDir, clientv3.EventTypePut, finishChanInternal, errChanInternal)
-	go This.WatchDir(failDir, clientv3.EventTypePut, failChanInternal, errChanInternal)
+	Op = clientv3.OpDelete(taskKey, clientv3.WithPrevKV())
+	if OpResp, err = This.kv.Do(context.TODO(), Op); err != nil{
+		return
+	}
 
-	return finishChanInternal, failChanInternal, errChanInternal
+	// 如果删除成功, 反序列化原来的任务
+	if len(OpResp.Del().PrevKvs) != 0{
+		if err = json.Unmarshal(OpResp.Del().PrevKvs[0].Value, &temp); err != nil{
+			logger.Logger.InfoLog("RemoveTask反序列化错误...已丢弃该错误:", err.Error())
+			return nil, nil
+		}
+		oldTask = &temp
+	}
+	return
 }
 
 func (This *TaskManager) GetTaskList () (taskList []*common.Task, err error) {
@@ -155,15 +678,28 @@ func (This *TaskManager) GetTaskList () (taskList []*common.Task, err error) {
 func (This *TaskManager) KillTask (task *common.Task) (err error){
 	// 更新etcd中该任务，将key更新到killer目录下（worker监听该目录，杀死任务）
 	var (
+		taskKey			string
 		taskKillerKey	string
+		killerValue		[]byte
 
 		leaseGrantResp	*clientv3.LeaseGrantResponse
 		leaseID			clientv3.LeaseID
 
 		Op 				clientv3.Op
+		OpResp			clientv3.OpResponse
 	)
+	taskKey = path.Join(path.Join(path.Join(config.Cfg.TaskDir, task.TaskType), strconv.Itoa(int(task.UserId))), task.TaskName)
 	taskKillerKey = path.Join(path.Join(path.Join(config.Cfg.KillerDir, task.TaskType), strconv.Itoa(int(task.UserId))), task.TaskName)
 
+	// 强杀标记中带上完整的任务(含任务id)，worker据此更新任务状态
+	Op = clientv3.OpGet(taskKey)
+	if OpResp, err = This.kv.Do(context.TODO(), Op); err != nil{
+		return
+	}
+	if len(OpResp.Get().Kvs) != 0 {
+		killerValue = OpResp.Get().Kvs[0].Value
+	}
+
 
 	// 申请租约(该key在强杀目录中存在2s，然后被删除。先触发PUT事件，然后触发删除事件)
 	if leaseGrantResp, err = TM.lease.Grant(context.TODO(), 2); err != nil{
@@ -174,7 +710,7 @@ func (This *TaskManager) KillTask (task *common.Task) (err error){
 	leaseID = leaseGrantResp.ID
 
 	// 置killer标记：将其put到killer目录下，worker监听到后强杀该任务
-	Op = clientv3.OpPut(taskKillerKey, "", clientv3.WithLease(leaseID))
+	Op = clientv3.OpPut(taskKillerKey, string(killerValue), clientv3.WithLease(leaseID))
 	if _, err = This.kv.Do(context.TODO(), Op); err != nil{
 		return
 	}
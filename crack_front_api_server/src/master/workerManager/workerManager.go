@@ -2,7 +2,9 @@ package workerManager
 
 import (
 	"context"
+	"crack_front/src/common"
 	"crack_front/src/config"
+	"encoding/json"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"strings"
@@ -35,6 +37,34 @@ func (This *WorkerManager) GetWorkers() (workers []string, err error) {
 	return
 }
 
+// 所有worker的执行容量(worker注册时写入，还没有发布容量的worker只有IP)
+func (This *WorkerManager) GetWorkerCapacities() (capacities []*common.WorkerCapacity, err error) {
+	var (
+		op				clientv3.Op
+		opResp			clientv3.OpResponse
+		kvPair			*mvccpb.KeyValue
+		capacity		*common.WorkerCapacity
+	)
+	capacities = make([]*common.WorkerCapacity, 0, 32)
+	op = clientv3.OpGet(config.Cfg.WorkersDir, clientv3.WithPrefix())
+	if opResp, err = This.kv.Do(context.TODO(), op); err != nil{
+		return
+	}
+
+	for _, kvPair = range opResp.Get().Kvs{
+		capacity = &common.WorkerCapacity{}
+		if len(kvPair.Value) != 0 {
+			if err = json.Unmarshal(kvPair.Value, capacity); err != nil{
+				return nil, err
+			}
+		}
+		capacity.Ip = strings.TrimPrefix(string(kvPair.Key), config.Cfg.WorkersDir)
+		capacities = append(capacities, capacity)
+	}
+
+	return
+}
+
 var (
 	WM					*WorkerManager
 )